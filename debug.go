@@ -42,6 +42,16 @@ nux debugger commands and keyboard shortcuts:
 	mem [ref]
 		View memory at the given reference,
 		or PC if not reference given.
+	date [time]
+		Print the time reported by the Datetime device, or set its
+		clock to the given time (in the format accepted by -date),
+		running in real time from then on.
+	freeze
+		Stop the Datetime clock at its current time.
+	tz [zone]
+		Print the time zone of the Datetime device, or report times
+		in the given time zone ("UTC", "Local", or a name such as
+		"Europe/Berlin").
 	exit  (^C)
 		Exit nux.
 	help
@@ -256,6 +266,34 @@ func NewDebugger() *Debugger {
 			default:
 				log.Printf("mem only takes one argument")
 			}
+		case "date":
+			if arg == "" {
+				log.Printf("date: %s", d.Runner.Now().Format(time.RFC3339))
+				return
+			}
+			t, err := parseDate(arg, d.Runner.Now().Location())
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			d.Runner.SetClock(varvara.ClockFrom(t))
+			log.Printf("date set: %s", t.Format(time.RFC3339))
+		case "freeze":
+			t := d.Runner.Now()
+			d.Runner.SetClock(varvara.FixedClock{Time: t})
+			log.Printf("date frozen: %s", t.Format(time.RFC3339))
+		case "tz":
+			if arg == "" {
+				log.Printf("time zone: %s", d.Runner.Now().Location())
+				return
+			}
+			loc, err := time.LoadLocation(arg)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			d.Runner.SetLocation(loc)
+			log.Printf("time zone set: %s", loc)
 		case "break", "rmbreak", "watch", "watch2", "rmwatch":
 			args := strings.Fields(arg)
 			for _, arg := range args {
@@ -307,6 +345,7 @@ func parseCommand(in string) (string, bool) {
 		"w2": "watch2", "watch2": "watch2",
 		"rmw": "rmwatch", "rmwatch": "rmwatch",
 		"m": "mem", "mem": "mem",
+		"date": "date", "freeze": "freeze", "tz": "tz",
 	}[in]; ok {
 		return out, true
 	}
@@ -357,6 +396,12 @@ func (d *Debugger) symbols() *symbols {
 	return d.syms
 }
 
+// Misuse logs the misuse of a device, with the label of the code
+// responsible if it is known.
+func (d *Debugger) Misuse(m varvara.Misuse) {
+	d.symbols().logMisuse(m)
+}
+
 func (d *Debugger) SetSymbols(s *symbols) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
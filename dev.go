@@ -14,7 +14,7 @@ import (
 	"github.com/nf/nux/varvara"
 )
 
-func devMode(enableGUI, enableDebug bool, talFile string) error {
+func devMode(opts *options, enableDebug bool, talFile string) error {
 	talFile = filepath.Clean(talFile)
 
 	watcher, err := fsnotify.NewWatcher()
@@ -38,8 +38,9 @@ func devMode(enableGUI, enableDebug bool, talFile string) error {
 	)
 	if enableDebug {
 		debug = NewDebugger()
-		runner = varvara.NewRunner(enableGUI, true, debug.StateFunc)
+		runner = opts.newRunner(true, debug.StateFunc)
 		runner.SetOutput(debug.Log)
+		runner.SetMisuseFunc(debug.Misuse)
 		debug.Runner = runner
 
 		log.SetPrefix("")
@@ -53,7 +54,7 @@ func devMode(enableGUI, enableDebug bool, talFile string) error {
 			runner.Debug("exit", 0)
 		}()
 	} else {
-		runner = varvara.NewRunner(enableGUI, true, nil)
+		runner = opts.newRunner(true, nil)
 	}
 
 	romCh := make(chan []byte)
@@ -98,7 +99,10 @@ func devMode(enableGUI, enableDebug bool, talFile string) error {
 			}
 		}
 	}()
-	code := runner.Run((<-romCh))
+	code, err := runner.Run((<-romCh))
+	if err != nil {
+		return err
+	}
 	return fmt.Errorf("dev: exit code: %d", code)
 }
 
@@ -5,21 +5,21 @@ go 1.20
 require (
 	github.com/gdamore/tcell/v2 v2.6.0
 	github.com/howeyc/fsnotify v0.9.0
+	github.com/jezek/xgb v1.1.0
 	github.com/rivo/tview v0.0.0-20230406072732-e22ce9588bb4
 	golang.org/x/exp/shiny v0.0.0-20230321023759-10a507213a29
 	golang.org/x/image v0.6.0
 	golang.org/x/mobile v0.0.0-20230301163155-e0f57694e12c
+	golang.org/x/term v0.5.0
 )
 
 require (
 	dmitri.shuralyov.com/gpu/mtl v0.0.0-20221208032759-85de2813cf6b // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20221017161538-93cebf72946b // indirect
-	github.com/jezek/xgb v1.1.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/rivo/uniseg v0.4.3 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/term v0.5.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
 )
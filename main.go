@@ -2,14 +2,24 @@
 package main
 
 import (
+	"archive/zip"
 	"flag"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/nf/nux/patch"
 	"github.com/nf/nux/varvara"
 )
 
@@ -17,79 +27,678 @@ func main() {
 	log.SetPrefix("nux: ")
 	log.SetFlags(0)
 
+	if len(os.Args) > 1 {
+		var sub func([]string) error
+		switch os.Args[1] {
+		case "pack":
+			sub = packMain
+		case "patch":
+			sub = patchMain
+		}
+		if sub != nil {
+			if err := sub(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var (
 		cliFlag   = flag.Bool("cli", false, "disable GUI features")
+		termFlag  = flag.String("term", "", "draw the screen in the terminal using `style` \"block\" or \"braille\" characters")
 		devFlag   = flag.Bool("dev", false, "enable developer mode (live re-build and run an untxal program)")
 		debugFlag = flag.Bool("debug", false, "enable debugger (implies -dev)")
 
+		scaleFlag   = flag.Int("scale", 2, "size the window to `n` times the screen size")
+		integerFlag = flag.Bool("integer", false, "scale the screen by whole multiples only (toggle with F2)")
+
+		keyMapFlag = flag.String("keymap", "", "read controller key bindings from `file`")
+		keyFlags   []string
+		listenFlag = flag.String("console-listen", "", "connect console input and output to a client of the TCP or Unix socket at `addr` (\"host:port\" or \"unix:path\")")
+		dialFlag   = flag.String("console-dial", "", "connect console input and output to the TCP or Unix socket at `addr`, reconnecting as needed")
+		rawFlag    = flag.Bool("raw", false, "put the terminal in raw mode, so that console input is delivered as it is typed (Ctrl-C exits)")
+		textFlag   = flag.String("text", "ascii", "deliver typed text in `mode` \"ascii\", \"utf8\" (UTF-8 on the key port), or \"console\" (UTF-8 to the console instead of stdin)")
+
+		dateFlag  = flag.String("date", "", "start the Datetime clock at `time` (RFC 3339, or \"YYYY-MM-DD [hh:mm[:ss]]\")")
+		clockFlag = flag.String("clock", "real", "run the Datetime clock in `mode` \"real\", \"fixed\", or \"frame\" (advancing 1/60s per screen frame)")
+		tzFlag    = flag.String("tz", "", "report times in the time `zone`, such as \"UTC\" or \"Europe/Berlin\"")
+
+		deterministicFlag = flag.Bool("deterministic", false, "run without a display, frame-locked clock, standard input, or file system access, so that runs are reproducible; without -replay-input, run until the program exits or -frames have run")
+		framesFlag        = flag.Uint64("frames", 0, "with -deterministic or -replay-input, stop after `n` frames")
+		screenshotFlag    = flag.String("screenshot", "", "write the final screen to `file` in PNG format")
+
+		recordFlag = flag.String("record-input", "", "record controller, mouse, and console input to `file`")
+		replayFlag = flag.String("replay-input", "", "replay the input recorded in `file` without a display, then exit")
+
+		fsRootFlag     = flag.String("fs-root", "", "give the File device the files in `dir` (default the current directory)")
+		fsZipFlag      = flag.String("fs-zip", "", "give the File device the files in the zip `archive`, beneath those of -fs-root if set, and otherwise keeping files written in memory")
+		fsReadOnlyFlag = flag.Bool("fs-readonly", false, "make the File device's files read-only")
+
+		haltOnMisuseFlag = flag.Bool("halt-on-misuse", false, "halt the program, running its halt vector, if it misuses a device")
+
 		cpuProfileFlag = flag.String("cpu_profile", "", "write CPU profile to `file`")
+
+		pipes     pipeGraph
+		allowExec []string
+		patches   []string
 	)
 
+	flag.Func("patch", "apply the IPS or BPS patch in `file` to the ROM before running it (repeatable)", func(s string) error {
+		patches = append(patches, s)
+		return nil
+	})
+
+	flag.Func("allow-exec", "allow ROMs to run the host `command` through the console (repeatable)", func(s string) error {
+		allowExec = append(allowExec, s)
+		return nil
+	})
+
+	flag.Func("pipe", "run the ROMs of a `pipeline` \"[name=][cli:]a.rom [arg ...] | b.rom ...\", each stage's console output piped to the next (repeatable; a stage may name an instance defined earlier)", pipes.add)
+
+	flag.Func("key", "bind a key to a controller button, as in `key=target` (repeatable)", func(s string) error {
+		if _, _, ok := strings.Cut(s, "="); !ok {
+			return fmt.Errorf("want key=target, got %q", s)
+		}
+		keyFlags = append(keyFlags, s)
+		return nil
+	})
+
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [-cli] <program.rom | program.tal>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "       %s [-cli] <-dev | -debug> <program.tal>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [-cli | -term style] <program.rom | program.tal | program.uxz> [arg ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-cli | -term style] <-dev | -debug> <program.tal> [arg ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-cli] -pipe pipeline [-pipe pipeline ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s pack [flags] <program.rom | program.tal | program.uxz>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s patch [flags] <old.rom> <new.rom>\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if len(pipes.nodes) > 0 {
+		if flag.NArg() > 0 {
+			flag.Usage()
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "term", "dev", "debug", "raw", "console-listen", "console-dial",
+				"deterministic", "frames", "screenshot", "record-input", "replay-input", "patch":
+				log.Fatalf("-%s cannot be used with -pipe", f.Name)
+			}
+		})
+		if *clockFlag == "frame" {
+			log.Fatal("-clock frame cannot be used with -pipe")
+		}
+	} else if flag.NArg() < 1 {
 		flag.Usage()
 	}
 
-	if *devFlag || *debugFlag {
-		if err := devMode(!*cliFlag, *debugFlag, flag.Arg(0)); err != nil {
+	opts := &options{
+		display: varvara.WindowDisplay,
+		scaling: varvara.Scaling{
+			Scale:   *scaleFlag,
+			Integer: *integerFlag,
+		},
+		keys:         varvara.DefaultKeyMap(),
+		allow:        allowExec,
+		haltOnMisuse: *haltOnMisuseFlag,
+		patches:      patches,
+	}
+	if flag.NArg() > 0 {
+		opts.args = flag.Args()[1:]
+	}
+	if f := *keyMapFlag; f != "" {
+		if err := loadKeyMap(opts.keys, f); err != nil {
+			log.Fatalf("reading key map: %v", err)
+		}
+	}
+	for _, kv := range keyFlags {
+		k, target, _ := strings.Cut(kv, "=")
+		if err := opts.keys.Set(k, target); err != nil {
+			log.Fatalf("-key %s: %v", kv, err)
+		}
+	}
+	switch *termFlag {
+	case "":
+	case "block":
+		opts.display = varvara.TerminalDisplay
+	case "braille":
+		opts.display = varvara.BrailleDisplay
+	default:
+		log.Fatalf("unknown terminal style %q", *termFlag)
+	}
+	if *cliFlag {
+		opts.display = varvara.NoDisplay
+	}
+	switch *textFlag {
+	case "ascii":
+		opts.text = varvara.ASCIIText
+	case "utf8":
+		opts.text = varvara.UTF8Text
+	case "console":
+		opts.text = varvara.ConsoleText
+		if opts.display == varvara.NoDisplay {
+			log.Fatal("-text console cannot be used with -cli")
+		}
+	default:
+		log.Fatalf("unknown text input mode %q", *textFlag)
+	}
+	if *tzFlag != "" {
+		loc, err := time.LoadLocation(*tzFlag)
+		if err != nil {
+			log.Fatalf("-tz: %v", err)
+		}
+		opts.location = loc
+	}
+	if *deterministicFlag {
+		if opts.location == nil {
+			opts.location = time.UTC
+		}
+		if *clockFlag == "real" {
+			*clockFlag = "frame"
+		} else if *clockFlag != "frame" {
+			log.Fatal("-deterministic requires -clock frame")
+		}
+	}
+	start := time.Now()
+	if *deterministicFlag {
+		start = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if *dateFlag != "" {
+		loc := opts.location
+		if loc == nil {
+			loc = time.Local
+		}
+		t, err := parseDate(*dateFlag, loc)
+		if err != nil {
+			log.Fatalf("-date: %v", err)
+		}
+		start = t
+		opts.clock = varvara.ClockFrom(start)
+	}
+	switch *clockFlag {
+	case "real":
+	case "fixed":
+		opts.clock = varvara.FixedClock{Time: start}
+	case "frame":
+		opts.clock = &varvara.FrameClock{Start: start}
+	default:
+		log.Fatalf("unknown clock mode %q", *clockFlag)
+	}
+	if *scaleFlag < 1 {
+		log.Fatal("-scale must be at least 1")
+	}
+	if *debugFlag && opts.display != varvara.WindowDisplay && opts.display != varvara.NoDisplay {
+		log.Fatal("-term cannot be used with -debug")
+	}
+
+	if *replayFlag != "" {
+		if opts.display != varvara.WindowDisplay && opts.display != varvara.NoDisplay {
+			log.Fatal("-term cannot be used with -replay-input")
+		}
+		opts.display = varvara.NoDisplay
+		f, err := os.Open(*replayFlag)
+		if err != nil {
 			log.Fatal(err)
 		}
-		return
+		opts.replay, err = varvara.ReadInputRecording(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("reading %s: %v", *replayFlag, err)
+		}
+	}
+	if *deterministicFlag {
+		if opts.display != varvara.WindowDisplay && opts.display != varvara.NoDisplay {
+			log.Fatal("-term cannot be used with -deterministic")
+		}
+		opts.display = varvara.NoDisplay
+		if opts.replay == nil {
+			// Without scripted input, run until the program exits.
+			opts.replay = &varvara.InputRecording{Frames: math.MaxUint64}
+		}
+		opts.fs = &varvara.MemFS{}
+		if len(opts.allow) > 0 {
+			log.Fatal("-allow-exec cannot be used with -deterministic")
+		}
+	}
+	if opts.replay != nil {
+		// Replayed programs receive console input only from the
+		// recording, and run without a display to type into.
+		mode := "-replay-input"
+		if *deterministicFlag {
+			mode = "-deterministic"
+		}
+		switch {
+		case *rawFlag:
+			log.Fatalf("-raw cannot be used with %s", mode)
+		case *listenFlag != "" || *dialFlag != "":
+			log.Fatalf("-console-listen and -console-dial cannot be used with %s", mode)
+		case opts.text == varvara.ConsoleText:
+			log.Fatalf("-text console cannot be used with %s", mode)
+		}
+	}
+	if root := *fsRootFlag; root != "" {
+		if *deterministicFlag {
+			log.Fatal("-fs-root cannot be used with -deterministic")
+		}
+		if fi, err := os.Stat(root); err != nil {
+			log.Fatalf("-fs-root: %v", err)
+		} else if !fi.IsDir() {
+			log.Fatalf("-fs-root: %s is not a directory", root)
+		}
+		opts.fs = varvara.DirFS(root)
+	}
+	if name := *fsZipFlag; name != "" {
+		z, err := zip.OpenReader(name)
+		if err != nil {
+			fatalf("-fs-zip: %v", err)
+		}
+		atExit(func() { z.Close() })
+		upper := opts.fs
+		if upper == nil {
+			upper = &varvara.MemFS{}
+		}
+		opts.fs = varvara.Overlay(upper, &z.Reader)
+	}
+	opts.readOnly = *fsReadOnlyFlag
+	if n := *framesFlag; n > 0 {
+		if opts.replay == nil {
+			fatal("-frames requires -deterministic or -replay-input")
+		}
+		opts.replay.Frames = n
+	}
+	opts.screenshot = *screenshotFlag
+
+	if *listenFlag != "" || *dialFlag != "" {
+		if *listenFlag != "" && *dialFlag != "" {
+			fatal("-console-listen and -console-dial cannot be used together")
+		}
+		if *rawFlag || opts.text == varvara.ConsoleText {
+			fatal("-console-listen and -console-dial cannot be used with -raw or -text console")
+		}
+		addr, listen := *listenFlag, true
+		if addr == "" {
+			addr, listen = *dialFlag, false
+		}
+		s, err := newSocketConsole(addr, listen)
+		if err != nil {
+			fatal(err)
+		}
+		atExit(func() { s.Close() })
+		opts.stdin, opts.stdout = s, s
+	}
+	if *rawFlag {
+		if opts.display != varvara.WindowDisplay && opts.display != varvara.NoDisplay {
+			fatal("-raw cannot be used with -term")
+		}
+		if *debugFlag {
+			fatal("-raw cannot be used with -debug")
+		}
+		if opts.text == varvara.ConsoleText {
+			fatal("-raw cannot be used with -text console")
+		}
+	}
+	dev := *devFlag || *debugFlag
+	if dev {
+		if *recordFlag != "" || *replayFlag != "" || *deterministicFlag {
+			fatal("-record-input, -replay-input, and -deterministic cannot be used with -dev or -debug")
+		}
+		if len(opts.patches) > 0 {
+			fatal("-patch cannot be used with -dev or -debug")
+		}
 	}
 
-	var cpuProfile io.Closer
-	if prof := *cpuProfileFlag; prof != "" {
+	if prof := *cpuProfileFlag; prof != "" && !dev {
 		f, err := os.Create(prof)
 		if err != nil {
-			log.Fatalf("creating CPU profile file: %v", err)
+			fatalf("creating CPU profile file: %v", err)
 		}
 		pprof.StartCPUProfile(f)
-		cpuProfile = f
+		atExit(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	if name := *recordFlag; name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			fatalf("creating input recording: %v", err)
+		}
+		opts.record = f
+		atExit(func() {
+			if err := f.Close(); err != nil {
+				log.Printf("writing input recording: %v", err)
+			}
+		})
 	}
 
-	code, err := run(flag.Arg(0), !*cliFlag)
+	// Stop the programs when interrupted, so that nux exits by way of
+	// cleanup. A second signal exits immediately.
+	opts.stop = &stopper{}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		s := <-sig
+		signal.Stop(sig)
+		code := 1
+		if s, ok := s.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		opts.stop.stop(code)
+	}()
 
-	if f := cpuProfile; f != nil {
-		pprof.StopCPUProfile()
-		f.Close()
+	if *rawFlag {
+		// Enter raw mode only once the flags are known to be valid,
+		// so that every exit restores the terminal.
+		stdin, restore, err := rawTerminal(func() { opts.stop.stop(130) })
+		if err != nil {
+			fatalf("-raw: %v", err)
+		}
+		atExit(restore)
+		opts.stdin = stdin
+		opts.raw = true
 	}
 
+	var (
+		code int
+		err  error
+	)
+	switch {
+	case dev:
+		err = devMode(opts, *debugFlag, flag.Arg(0))
+	case len(pipes.nodes) > 0:
+		code, err = runPipes(&pipes, opts)
+	default:
+		code, err = run(flag.Arg(0), opts)
+	}
+	if c, ok := opts.stop.stopped(); ok {
+		code = c
+	}
+
+	cleanup()
 	if err != nil {
 		log.Fatal(err)
 	}
 	os.Exit(code)
 }
 
-func run(romFile string, guiEnabled bool) (int, error) {
-	var (
-		rom []byte
-		err error
-	)
-	if filepath.Ext(romFile) == ".tal" {
-		tmp, err := os.MkdirTemp("", "nux-build-*")
+// exitFuncs are called by cleanup before nux exits.
+var exitFuncs []func()
+
+// atExit arranges for f to be called before nux exits normally,
+// such as to restore the terminal or remove a socket file.
+func atExit(f func()) { exitFuncs = append(exitFuncs, f) }
+
+func cleanup() {
+	for i := len(exitFuncs) - 1; i >= 0; i-- {
+		exitFuncs[i]()
+	}
+}
+
+// fatal and fatalf are like log.Fatal and log.Fatalf,
+// but first call the functions registered with atExit.
+func fatal(v ...any) {
+	cleanup()
+	log.Fatal(v...)
+}
+
+func fatalf(format string, v ...any) {
+	cleanup()
+	log.Fatalf(format, v...)
+}
+
+// A stopper stops the Runners made by nux when it is interrupted.
+type stopper struct {
+	mu      sync.Mutex
+	runners []*varvara.Runner
+	done    bool
+	code    int // the exit code, once stopped
+}
+
+// add arranges for r to be stopped, stopping it now if s is stopped.
+func (s *stopper) add(r *varvara.Runner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runners = append(s.runners, r)
+	if s.done {
+		r.Stop()
+	}
+}
+
+// stop stops the Runners, making nux exit with the given code.
+func (s *stopper) stop(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done, s.code = true, code
+	for _, r := range s.runners {
+		r.Stop()
+	}
+}
+
+// stopped returns the exit code given to stop, if it was called.
+func (s *stopper) stopped() (code int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.code, s.done
+}
+
+// parseInterspersed parses the flags of a subcommand from args, which
+// may follow its other arguments, as in "nux pack a.rom -o a", and
+// returns the other arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return rest
+		}
+		rest = append(rest, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// options holds the command-line options that configure a Runner.
+type options struct {
+	display varvara.Display
+	scaling varvara.Scaling
+	keys    varvara.KeyMap
+	text    varvara.TextInput
+	record  io.Writer
+	replay  *varvara.InputRecording
+
+	clock    varvara.Clock  // or nil for the real time
+	location *time.Location // or nil for local time
+
+	args       []string   // passed to the Console device
+	stdin      io.Reader  // or nil for standard input
+	stdout     io.Writer  // or nil for standard output
+	raw        bool       // the terminal is in raw mode
+	fs         varvara.FS // or nil for the current directory
+	readOnly   bool       // make the files of fs read-only
+	allow      []string   // host commands that ROMs may run
+	screenshot string     // PNG file to write the final screen to
+
+	haltOnMisuse bool // halt programs that misuse a device
+
+	title   string   // the window title, or empty for the default
+	syms    *symbols // the program's symbols, for diagnostics, or nil
+	patches []string // IPS or BPS patch files to apply to the ROM, in order
+	stop    *stopper // stops the Runner when nux is interrupted, or nil
+}
+
+func (o *options) newRunner(devMode bool, state varvara.StateFunc) *varvara.Runner {
+	r := varvara.NewRunner(o.display, devMode, state)
+	r.SetScaling(o.scaling)
+	r.SetKeyMap(o.keys)
+	r.SetTextInput(o.text)
+	r.SetArgs(o.args)
+	if o.stdin != nil {
+		r.SetInput(o.stdin)
+	}
+	if o.stdout != nil {
+		r.SetStdout(o.stdout)
+	}
+	r.SetRawConsole(o.raw)
+	r.SetRecordInput(o.record)
+	r.SetReplayInput(o.replay)
+	if o.clock != nil {
+		r.SetClock(o.clock)
+	}
+	if o.location != nil {
+		r.SetLocation(o.location)
+	}
+	if fsys := o.fs; fsys != nil || o.readOnly {
+		if fsys == nil {
+			fsys = varvara.DirFS(".")
+		}
+		if o.readOnly {
+			fsys = varvara.ReadOnly(fsys)
+		}
+		r.SetFS(fsys)
+	}
+	r.SetAllowedCommands(o.allow)
+	r.SetHaltOnMisuse(o.haltOnMisuse)
+	if o.title != "" {
+		r.SetTitle(o.title)
+	}
+	if o.syms != nil {
+		r.SetMisuseFunc(o.syms.logMisuse)
+	}
+	if o.stop != nil {
+		o.stop.add(r)
+	}
+	return r
+}
+
+// load reads the named program, which may be a ROM, a uxntal source
+// file, or a bundle (see varvara.Bundle), and returns its ROM with the
+// patches of o applied.
+// A bundle's assets are given to the File device beneath any files of
+// o.fs, which otherwise keeps files written in memory, and its default
+// arguments are used if o has none.
+func (o *options) load(file string) ([]byte, error) {
+	rom, err := o.loadProgram(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range o.patches {
+		p, err := os.ReadFile(name)
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+		if rom, err = patch.Apply(rom, p); err != nil {
+			return nil, fmt.Errorf("applying %s: %v", name, err)
+		}
+	}
+	return rom, nil
+}
+
+func (o *options) loadProgram(file string) ([]byte, error) {
+	switch filepath.Ext(file) {
+	case ".uxz", ".zip":
+	default:
+		return readROM(file)
+	}
+	b, err := varvara.OpenBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	if b.Symbols != nil {
+		if o.syms, err = parseSymbolData(b.Symbols); err != nil {
+			return nil, fmt.Errorf("reading bundle %s: symbols: %v", file, err)
 		}
-		defer os.RemoveAll(tmp)
+	}
+	upper := o.fs
+	if upper == nil {
+		upper = &varvara.MemFS{}
+	}
+	o.fs = varvara.Overlay(upper, b.Assets)
+	if len(o.args) == 0 {
+		o.args = b.Args()
+	}
+	if name := b.Name(); name != "" && o.title == "" {
+		o.title = "nux: " + name
+	}
+	return b.ROM, nil
+}
+
+func loadKeyMap(m varvara.KeyMap, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Load(f)
+}
 
-		talFile := romFile
-		romFile = filepath.Join(tmp, filepath.Base(talFile)+".rom")
-		rom, err = devBuild(os.Stderr, talFile, romFile)
-	} else {
-		rom, err = os.ReadFile(romFile)
+// parseDate parses a date and time given on the command line or to the
+// debugger, in RFC 3339 format or as a date optionally followed by a time.
+// Times without a time zone are interpreted in loc.
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{
+		"2006-01-02",
+		"2006-01-02 15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02T15:04:05",
+	} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("bad date %q", s)
+}
+
+func run(romFile string, opts *options) (int, error) {
+	rom, err := opts.load(romFile)
 	if err != nil {
 		return 0, err
 	}
 
-	r := varvara.NewRunner(guiEnabled, false, nil)
-	code := r.Run(rom)
+	r := opts.newRunner(false, nil)
+	code, err := r.Run(rom)
+	if err != nil {
+		return code, err
+	}
+
+	if name := opts.screenshot; name != "" {
+		if err := writePNG(name, r.Screenshot()); err != nil {
+			return code, fmt.Errorf("writing screenshot: %v", err)
+		}
+	}
 
 	return code, nil
 }
+
+// readROM reads the named ROM file,
+// first assembling it if it is a uxntal source file.
+func readROM(romFile string) ([]byte, error) {
+	if filepath.Ext(romFile) != ".tal" {
+		return os.ReadFile(romFile)
+	}
+	tmp, err := os.MkdirTemp("", "nux-build-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	talFile := romFile
+	romFile = filepath.Join(tmp, filepath.Base(talFile)+".rom")
+	return devBuild(os.Stderr, talFile, romFile)
+}
+
+func writePNG(name string, m image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
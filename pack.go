@@ -0,0 +1,275 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/template"
+
+	"github.com/nf/nux/varvara"
+)
+
+// packMain implements "nux pack", which builds a standalone executable
+// that embeds a program and runs it as nux would.
+func packMain(args []string) error {
+	flags := flag.NewFlagSet("pack", flag.ExitOnError)
+	var (
+		outFlag     = flags.String("o", "", "write the executable to `file` (default the program's name)")
+		cliFlag     = flags.Bool("cli", false, "disable GUI features")
+		termFlag    = flags.String("term", "", "draw the screen in the terminal using `style` \"block\" or \"braille\" characters")
+		scaleFlag   = flags.Int("scale", 2, "size the window to `n` times the screen size")
+		integerFlag = flags.Bool("integer", false, "scale the screen by whole multiples only")
+		titleFlag   = flags.String("title", "", "set the window `title` (default the program's name)")
+		targetFlag  = flags.String("target", "", "build for the `os/arch`, such as \"windows/amd64\" (default $GOOS/$GOARCH; windowed programs for darwin must be packed on a Mac)")
+		nuxFlag     = flags.String("nux", "", "build with the nux module at `version`, or in the source directory (default this nux's version)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s pack [flags] <program.rom | program.tal | program.uxz>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	files := parseInterspersed(flags, args)
+	if len(files) != 1 {
+		flags.Usage()
+	}
+	file := files[0]
+
+	p := packProgram{
+		Display: "varvara.WindowDisplay",
+		Scale:   *scaleFlag,
+		Integer: *integerFlag,
+	}
+	if *scaleFlag < 1 {
+		return fmt.Errorf("-scale must be at least 1")
+	}
+	switch *termFlag {
+	case "":
+	case "block":
+		p.Display = "varvara.TerminalDisplay"
+	case "braille":
+		p.Display = "varvara.BrailleDisplay"
+	default:
+		return fmt.Errorf("unknown terminal style %q", *termFlag)
+	}
+	if *cliFlag {
+		p.Display = "varvara.NoDisplay"
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	var data []byte
+	switch filepath.Ext(file) {
+	case ".uxz", ".zip":
+		b, err := varvara.OpenBundle(file)
+		if err != nil {
+			return err
+		}
+		if b.Name() != "" {
+			name = b.Name()
+		}
+		if data, err = os.ReadFile(file); err != nil {
+			return err
+		}
+		p.Bundle = true
+	default:
+		rom, err := readROM(file)
+		if err != nil {
+			return err
+		}
+		data = rom
+	}
+	p.Title = *titleFlag
+	if p.Title == "" {
+		p.Title = name
+	}
+
+	goos, goarch := goEnv("GOOS", runtime.GOOS), goEnv("GOARCH", runtime.GOARCH)
+	if t := *targetFlag; t != "" {
+		var ok bool
+		goos, goarch, ok = strings.Cut(t, "/")
+		if !ok || goos == "" || goarch == "" {
+			return fmt.Errorf("-target: want os/arch, got %q", t)
+		}
+	}
+
+	if goos == "darwin" && runtime.GOOS != "darwin" && p.Display == "varvara.WindowDisplay" {
+		// Windows on macOS need cgo, which is
+		// cannot be used when cross-compiling from another OS.
+		return fmt.Errorf("windowed programs for macOS must be packed on a Mac; use -cli or -term to pack for a terminal")
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if goos == "windows" {
+			out += ".exe"
+		}
+	}
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+
+	version, dir, err := nuxModule(*nuxFlag)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "nux-pack-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	p.File = "program.rom"
+	if p.Bundle {
+		p.File = "program.uxz"
+	}
+	if err := os.WriteFile(filepath.Join(tmp, p.File), data, 0644); err != nil {
+		return err
+	}
+	var mod strings.Builder
+	fmt.Fprintf(&mod, "module nuxpack\n\ngo 1.20\n\nrequire github.com/nf/nux %s\n", version)
+	if dir != "" {
+		fmt.Fprintf(&mod, "\nreplace github.com/nf/nux => %s\n", dir)
+		// Start from nux's checksums, so that its dependencies
+		// need not be fetched again only to verify them.
+		if sum, err := os.ReadFile(filepath.Join(dir, "go.sum")); err == nil {
+			if err := os.WriteFile(filepath.Join(tmp, "go.sum"), sum, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "go.mod"), []byte(mod.String()), 0644); err != nil {
+		return err
+	}
+	var src strings.Builder
+	if err := packTemplate.Execute(&src, p); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "main.go"), []byte(src.String()), 0644); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", "build", "-mod=mod", "-trimpath", "-o", out, ".")
+	cmd.Dir = tmp
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "GOFLAGS=")
+	if goos == "darwin" && p.Display == "varvara.WindowDisplay" {
+		// Enable cgo even when building for another architecture.
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("building %s for %s/%s: %v", out, goos, goarch, err)
+	}
+	return nil
+}
+
+// goEnv returns the value of the named Go environment variable,
+// or def if it is not set.
+func goEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// nuxModule returns the version of the nux module to build packed
+// programs with, and its source directory if it is to be built from
+// source. If spec is empty, it is the version of the running nux.
+func nuxModule(spec string) (version, dir string, err error) {
+	if spec == "" {
+		var v string
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			v = bi.Main.Version
+		}
+		v, err := fetchableVersion(v)
+		return v, "", err
+	}
+	if fi, err := os.Stat(spec); err == nil && fi.IsDir() {
+		dir, err := filepath.Abs(spec)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+			return "", "", fmt.Errorf("-nux: %s is not a module directory", spec)
+		}
+		return "v0.0.0", dir, nil
+	}
+	return spec, "", nil
+}
+
+// fetchableVersion returns v, the version of the running nux, if it
+// is one that the go command can fetch.
+func fetchableVersion(v string) (string, error) {
+	switch {
+	case v == "" || v == "(devel)":
+		return "", fmt.Errorf("cannot tell which version of nux this is; use -nux to give a version or source directory")
+	case strings.HasSuffix(v, "+dirty"):
+		return "", fmt.Errorf("this nux was built from modified source (%s), which cannot be fetched; use -nux to give a version or source directory", v)
+	}
+	return v, nil
+}
+
+// packProgram configures the program generated by packTemplate.
+type packProgram struct {
+	File    string // the embedded ROM or bundle
+	Bundle  bool
+	Display string // the Go expression for the varvara.Display
+	Scale   int
+	Integer bool
+	Title   string
+}
+
+var packTemplate = template.Must(template.New("main.go").Parse(`// Code generated by nux pack. DO NOT EDIT.
+
+package main
+
+import (
+{{- if .Bundle}}
+	"bytes"
+{{- end}}
+	_ "embed"
+	"log"
+	"os"
+
+	"github.com/nf/nux/varvara"
+)
+
+//go:embed {{.File}}
+var program []byte
+
+func main() {
+	log.SetPrefix({{printf "%q" .Title}} + ": ")
+	log.SetFlags(0)
+
+	r := varvara.NewRunner({{.Display}}, false, nil)
+	r.SetScaling(varvara.Scaling{Scale: {{.Scale}}, Integer: {{.Integer}}})
+	r.SetKeyMap(varvara.DefaultKeyMap())
+	r.SetTitle({{printf "%q" .Title}})
+	r.SetArgs(os.Args[1:])
+{{- if .Bundle}}
+
+	b, err := varvara.ReadBundle(bytes.NewReader(program), int64(len(program)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.SetFS(varvara.Overlay(&varvara.MemFS{}, b.Assets))
+	if len(os.Args) < 2 {
+		r.SetArgs(b.Args())
+	}
+	code, err := r.Run(b.ROM)
+{{- else}}
+	code, err := r.Run(program)
+{{- end}}
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(code)
+}
+`))
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// checkGolden compares got to the file testdata/name,
+// or writes got to that file if the -update flag is set.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestPackTemplate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		p    packProgram
+	}{
+		{"pack-rom.golden", packProgram{
+			File:    "program.rom",
+			Display: "varvara.WindowDisplay",
+			Scale:   2,
+			Title:   "hello",
+		}},
+		{"pack-bundle.golden", packProgram{
+			File:    "program.uxz",
+			Bundle:  true,
+			Display: "varvara.TerminalDisplay",
+			Scale:   3,
+			Integer: true,
+			Title:   `say "hi"`,
+		}},
+	} {
+		var src strings.Builder
+		if err := packTemplate.Execute(&src, test.p); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		// The generated program should be as gofmt would write it.
+		formatted, err := format.Source([]byte(src.String()))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if string(formatted) != src.String() {
+			t.Errorf("%s: generated source is not formatted", test.name)
+		}
+		checkGolden(t, test.name, src.String())
+	}
+}
+
+func TestFetchableVersion(t *testing.T) {
+	for _, test := range []struct {
+		v, err string
+	}{
+		{"v1.2.3", ""},
+		{"v0.0.0-20240101000000-0123456789ab", ""},
+		{"", "cannot tell which version"},
+		{"(devel)", "cannot tell which version"},
+		{"v0.0.0-20240101000000-0123456789ab+dirty", "modified source"},
+	} {
+		v, err := fetchableVersion(test.v)
+		switch {
+		case test.err == "" && (err != nil || v != test.v):
+			t.Errorf("fetchableVersion(%q) = %q, %v; want %q", test.v, v, err, test.v)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), "-nux")):
+			t.Errorf("fetchableVersion(%q) error = %v, want %q and a suggestion of -nux", test.v, err, test.err)
+		}
+	}
+}
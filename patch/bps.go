@@ -0,0 +1,196 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// BPS patches are the magic "BPS1", the source size, target size, and
+// metadata size as variable-length numbers, the metadata, a series of
+// actions that produce the target, and the little-endian CRC-32s of the
+// source, target, and the patch up to its own checksum.
+//
+// Each action is a number holding the kind of action in its low 2 bits
+// and its length, less one, in the rest:
+//
+//	sourceRead: copy from the source at the current output offset
+//	targetRead: copy the bytes that follow in the patch
+//	sourceCopy: copy from the source at an offset moved by a signed
+//	            number that follows
+//	targetCopy: copy from the output at an offset moved likewise
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+var errBPSTruncated = errors.New("patch: truncated BPS patch")
+
+// ApplyBPS returns the result of applying the BPS patch to rom,
+// verifying the checksums of the patch, rom, and the result.
+func ApplyBPS(rom, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, bpsMagic) {
+		return nil, errors.New("patch: not a BPS patch")
+	}
+	if len(patch) < len(bpsMagic)+3+12 {
+		return nil, errBPSTruncated
+	}
+	body, sums := patch[:len(patch)-12], patch[len(patch)-12:]
+	srcSum := binary.LittleEndian.Uint32(sums[0:])
+	dstSum := binary.LittleEndian.Uint32(sums[4:])
+	patchSum := binary.LittleEndian.Uint32(sums[8:])
+	if sum := crc32.ChecksumIEEE(patch[:len(patch)-4]); sum != patchSum {
+		return nil, fmt.Errorf("patch: corrupt BPS patch (CRC-32 %08x, want %08x)", sum, patchSum)
+	}
+	if sum := crc32.ChecksumIEEE(rom); sum != srcSum {
+		return nil, fmt.Errorf("patch: BPS patch is for a different ROM (CRC-32 %08x, want %08x)", sum, srcSum)
+	}
+
+	d := bpsDecoder{p: body[len(bpsMagic):]}
+	srcSize, dstSize, metaSize := d.number(), d.number(), d.number()
+	if d.err == nil && uint64(len(d.p)) < metaSize {
+		d.err = errBPSTruncated
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	d.p = d.p[metaSize:]
+	if srcSize != uint64(len(rom)) {
+		return nil, fmt.Errorf("patch: BPS patch is for a %d-byte ROM, not %d bytes", srcSize, len(rom))
+	}
+	if dstSize > 1<<24 {
+		return nil, fmt.Errorf("patch: BPS patch target size %d is too large", dstSize)
+	}
+
+	out := make([]byte, 0, dstSize)
+	var srcOff, dstOff int64
+	for len(d.p) > 0 && d.err == nil {
+		action := d.number()
+		n := int(action>>2) + 1
+		if uint64(len(out)+n) > dstSize {
+			return nil, errors.New("patch: BPS patch writes past the end of the target")
+		}
+		switch action & 3 {
+		case bpsSourceRead:
+			if len(out)+n > len(rom) {
+				return nil, errors.New("patch: BPS patch reads past the end of the source")
+			}
+			out = append(out, rom[len(out):len(out)+n]...)
+		case bpsTargetRead:
+			if len(d.p) < n {
+				return nil, errBPSTruncated
+			}
+			out = append(out, d.p[:n]...)
+			d.p = d.p[n:]
+		case bpsSourceCopy:
+			srcOff += d.offset()
+			if srcOff < 0 || srcOff+int64(n) > int64(len(rom)) {
+				return nil, errors.New("patch: BPS patch copies from outside the source")
+			}
+			out = append(out, rom[srcOff:srcOff+int64(n)]...)
+			srcOff += int64(n)
+		case bpsTargetCopy:
+			dstOff += d.offset()
+			if dstOff < 0 || dstOff >= int64(len(out)) {
+				return nil, errors.New("patch: BPS patch copies from outside the target")
+			}
+			// The copy may overlap the bytes it writes.
+			for i := 0; i < n; i++ {
+				out = append(out, out[dstOff])
+				dstOff++
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, fmt.Errorf("patch: BPS patch produced %d bytes, want %d", len(out), dstSize)
+	}
+	if sum := crc32.ChecksumIEEE(out); sum != dstSum {
+		return nil, fmt.Errorf("patch: BPS patch produced the wrong ROM (CRC-32 %08x, want %08x)", sum, dstSum)
+	}
+	return out, nil
+}
+
+// bpsDecoder reads the variable-length numbers of a BPS patch.
+type bpsDecoder struct {
+	p   []byte
+	err error
+}
+
+func (d *bpsDecoder) number() uint64 {
+	var n, shift uint64 = 0, 1
+	for {
+		if len(d.p) == 0 || shift > 1<<56 {
+			if d.err == nil {
+				d.err = errBPSTruncated
+			}
+			return 0
+		}
+		b := d.p[0]
+		d.p = d.p[1:]
+		n += uint64(b&0x7f) * shift
+		if b&0x80 != 0 {
+			return n
+		}
+		shift <<= 7
+		n += shift
+	}
+}
+
+// offset reads a signed offset, whose low bit is its sign.
+func (d *bpsDecoder) offset() int64 {
+	n := d.number()
+	if n&1 != 0 {
+		return -int64(n >> 1)
+	}
+	return int64(n >> 1)
+}
+
+func appendBPSNumber(p []byte, n uint64) []byte {
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(p, b|0x80)
+		}
+		p = append(p, b)
+		n--
+	}
+}
+
+// CreateBPS returns a BPS patch that transforms src into dst.
+// It copies the bytes of src that are unchanged in place, and includes
+// the rest of dst in the patch; it does not look for data that moved.
+func CreateBPS(src, dst []byte) []byte {
+	p := append([]byte(nil), bpsMagic...)
+	p = appendBPSNumber(p, uint64(len(src)))
+	p = appendBPSNumber(p, uint64(len(dst)))
+	p = appendBPSNumber(p, 0) // no metadata
+
+	same := func(i int) bool { return i < len(src) && src[i] == dst[i] }
+	for i := 0; i < len(dst); {
+		action, n := bpsSourceRead, 1
+		if !same(i) {
+			action = bpsTargetRead
+		}
+		for i+n < len(dst) && same(i+n) == (action == bpsSourceRead) {
+			n++
+		}
+		p = appendBPSNumber(p, uint64(n-1)<<2|uint64(action))
+		if action == bpsTargetRead {
+			p = append(p, dst[i:i+n]...)
+		}
+		i += n
+	}
+
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(src))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(dst))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
@@ -0,0 +1,169 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// IPS patches are the magic "PATCH" followed by records, each a 3-byte
+// offset and 2-byte length followed by that many bytes to write at the
+// offset. A record with length 0 instead holds a 2-byte count and a
+// byte to repeat. The records end with "EOF", optionally followed by a
+// 3-byte length to truncate the result to.
+
+const (
+	ipsEOF       = 0x454f46 // "EOF", which cannot be a record offset
+	ipsMaxOffset = 0xffffff
+	ipsMaxLen    = 0xffff
+)
+
+var errIPSTruncated = errors.New("patch: truncated IPS patch")
+
+// ApplyIPS returns the result of applying the IPS patch to rom.
+func ApplyIPS(rom, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, ipsMagic) {
+		return nil, errors.New("patch: not an IPS patch")
+	}
+	p := patch[len(ipsMagic):]
+	out := append([]byte(nil), rom...)
+	for {
+		if len(p) < 3 {
+			return nil, errIPSTruncated
+		}
+		off := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		p = p[3:]
+		if off == ipsEOF {
+			break
+		}
+		if len(p) < 2 {
+			return nil, errIPSTruncated
+		}
+		n := int(p[0])<<8 | int(p[1])
+		p = p[2:]
+		var data []byte
+		if n == 0 {
+			if len(p) < 3 {
+				return nil, errIPSTruncated
+			}
+			n = int(p[0])<<8 | int(p[1])
+			data = bytes.Repeat(p[2:3], n)
+			p = p[3:]
+		} else {
+			if len(p) < n {
+				return nil, errIPSTruncated
+			}
+			data, p = p[:n], p[n:]
+		}
+		if end := off + n; end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[off:], data)
+	}
+	switch len(p) {
+	case 0:
+	case 3:
+		if n := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); n < len(out) {
+			out = out[:n]
+		}
+	default:
+		return nil, fmt.Errorf("patch: %d bytes of trailing data in IPS patch", len(p))
+	}
+	return out, nil
+}
+
+// CreateIPS returns an IPS patch that transforms src into dst.
+// Runs of a repeated byte are written as run-length encoded records.
+// It returns an error if dst is too large for the format.
+func CreateIPS(src, dst []byte) ([]byte, error) {
+	if len(dst) > ipsMaxOffset {
+		return nil, fmt.Errorf("patch: %d-byte ROM is too large for IPS", len(dst))
+	}
+	differs := func(i int) bool { return i >= len(src) || src[i] != dst[i] }
+
+	p := append([]byte(nil), ipsMagic...)
+	for i := 0; i < len(dst); {
+		if !differs(i) {
+			i++
+			continue
+		}
+		// Extend the record over short runs of unchanged bytes,
+		// which cost less to include than to start a new record.
+		start, end := i, i+1
+		for end < len(dst) && end-start < ipsMaxLen {
+			if differs(end) {
+				end++
+				continue
+			}
+			j := end
+			for j < len(dst) && j-end < 5 && !differs(j) {
+				j++
+			}
+			if j == len(dst) || j-end == 5 || j-start > ipsMaxLen {
+				break
+			}
+			end = j
+		}
+		if start == ipsEOF {
+			// The offset would read as the end of the patch,
+			// so start the record a byte earlier.
+			start--
+		}
+		p = appendIPSRecords(p, start, dst[start:end])
+		i = end
+	}
+	p = append(p, "EOF"...)
+	if len(dst) < len(src) {
+		p = append(p, byte(len(dst)>>16), byte(len(dst)>>8), byte(len(dst)))
+	}
+	return p, nil
+}
+
+// appendIPSRecords appends records writing data at off to p,
+// using run-length encoded records for runs of a repeated byte.
+func appendIPSRecords(p []byte, off int, data []byte) []byte {
+	// run returns the length of the run of repeated bytes at data[i],
+	// up to limit.
+	run := func(i, limit int) int {
+		n := 1
+		for i+n < len(data) && n < limit && data[i+n] == data[i] {
+			n++
+		}
+		return n
+	}
+	const minRun = 8 // shorter runs are written as literal bytes
+	for len(data) > 0 {
+		n := run(0, ipsMaxLen)
+		rle := n >= minRun
+		if !rle {
+			// Write bytes up to the next long run.
+			for n = 0; n < len(data) && n < ipsMaxLen; {
+				r := run(n, minRun)
+				if r == minRun {
+					break
+				}
+				n += r
+			}
+			if n > ipsMaxLen {
+				n = ipsMaxLen
+			}
+		}
+		if off+n == ipsEOF && n < len(data) {
+			// The next record cannot start at this offset.
+			if n > 1 {
+				n--
+			} else {
+				n++
+			}
+		}
+		p = append(p, byte(off>>16), byte(off>>8), byte(off))
+		if rle {
+			p = append(p, 0, 0, byte(n>>8), byte(n), data[0])
+		} else {
+			p = append(p, byte(n>>8), byte(n))
+			p = append(p, data[:n]...)
+		}
+		off, data = off+n, data[n:]
+	}
+	return p
+}
@@ -0,0 +1,74 @@
+// Package patch applies and creates IPS and BPS patches to ROM files.
+//
+// IPS patches replace ranges of bytes, and may extend or truncate a ROM.
+// BPS patches also describe moved data, and carry CRC-32 checksums of the
+// original ROM, the patched ROM, and the patch itself, which are verified
+// when the patch is applied.
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A Format is a patch file format.
+type Format int
+
+const (
+	IPS Format = iota + 1
+	BPS
+)
+
+func (f Format) String() string {
+	switch f {
+	case IPS:
+		return "IPS"
+	case BPS:
+		return "BPS"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+var (
+	ipsMagic = []byte("PATCH")
+	bpsMagic = []byte("BPS1")
+)
+
+// ErrUnknownFormat is returned by Apply for a patch
+// that is neither in IPS nor BPS format.
+var ErrUnknownFormat = errors.New("patch: not an IPS or BPS patch")
+
+// Detect returns the format of patch, or 0 if it is not known.
+func Detect(patch []byte) Format {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return IPS
+	case bytes.HasPrefix(patch, bpsMagic):
+		return BPS
+	}
+	return 0
+}
+
+// Apply returns the result of applying patch, in either format, to rom.
+// It does not modify rom.
+func Apply(rom, patch []byte) ([]byte, error) {
+	switch Detect(patch) {
+	case IPS:
+		return ApplyIPS(rom, patch)
+	case BPS:
+		return ApplyBPS(rom, patch)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Create returns a patch in the given format that transforms src into dst.
+func Create(f Format, src, dst []byte) ([]byte, error) {
+	switch f {
+	case IPS:
+		return CreateIPS(src, dst)
+	case BPS:
+		return CreateBPS(src, dst), nil
+	}
+	return nil, fmt.Errorf("patch: unknown format %v", f)
+}
@@ -0,0 +1,149 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rom := make([]byte, 0x300)
+	for i := range rom {
+		rom[i] = byte(i * 7)
+	}
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), rom...))
+	}
+	big := make([]byte, ipsEOF+0x20)
+	for _, c := range []struct {
+		desc     string
+		src, dst []byte
+	}{
+		{"unchanged", rom, rom},
+		{"one byte", rom, edit(func(b []byte) []byte { b[0x10] = 0xff; return b })},
+		{"scattered", rom, edit(func(b []byte) []byte { b[1], b[4], b[0x200] = 0, 0, 0; return b })},
+		{"run", rom, edit(func(b []byte) []byte { copy(b[0x20:], bytes.Repeat([]byte{0xaa}, 0x40)); return b })},
+		{"grown", rom, edit(func(b []byte) []byte { return append(b, "more"...) })},
+		{"grown with zeros", rom, edit(func(b []byte) []byte { return append(b, 0, 0, 0) })},
+		{"shrunk", rom, rom[:0x123]},
+		{"empty", rom, nil},
+		{"from empty", nil, rom},
+		{"long record", nil, bytes.Repeat([]byte("0123456789"), 0x2000)},
+		{"at EOF offset", big, append(append([]byte(nil), big[:ipsEOF]...), "abc"...)},
+		{"run at EOF offset", big, append(append([]byte(nil), big[:ipsEOF-3]...), bytes.Repeat([]byte{1}, 20)...)},
+	} {
+		for _, f := range []Format{IPS, BPS} {
+			p, err := Create(f, c.src, c.dst)
+			if err != nil {
+				t.Errorf("%s: creating %v patch: %v", c.desc, f, err)
+				continue
+			}
+			if got := Detect(p); got != f {
+				t.Errorf("%s: detected %v patch as %v", c.desc, f, got)
+			}
+			got, err := Apply(c.src, p)
+			if err != nil {
+				t.Errorf("%s: applying %v patch: %v", c.desc, f, err)
+				continue
+			}
+			if !bytes.Equal(got, c.dst) {
+				t.Errorf("%s: applying %v patch gave %d bytes, want %d", c.desc, f, len(got), len(c.dst))
+			}
+		}
+	}
+}
+
+func TestApplyIPS(t *testing.T) {
+	p := []byte("PATCH" +
+		"\x00\x00\x01\x00\x02ab" + // write "ab" at 1
+		"\x00\x00\x05\x00\x00\x00\x03z" + // write "zzz" at 5
+		"EOF" +
+		"\x00\x00\x07") // truncate to 7 bytes
+	got, err := ApplyIPS([]byte("0123456789"), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0ab34zz"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"PATCH", "PATCH\x00\x00\x01\x00\x05ab", "PATCHEOF\x00", "nope"} {
+		if _, err := ApplyIPS([]byte("0123"), []byte(bad)); err == nil {
+			t.Errorf("applying %q: no error", bad)
+		}
+	}
+}
+
+// bpsPatch returns a BPS patch with the given actions and checksums.
+func bpsPatch(src, dst []byte, actions ...[]byte) []byte {
+	p := []byte("BPS1")
+	p = appendBPSNumber(p, uint64(len(src)))
+	p = appendBPSNumber(p, uint64(len(dst)))
+	p = appendBPSNumber(p, 4)
+	p = append(p, "meta"...)
+	for _, a := range actions {
+		p = append(p, a...)
+	}
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(src))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(dst))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+func bpsAction(kind, n int, data ...byte) []byte {
+	return append(appendBPSNumber(nil, uint64(n-1)<<2|uint64(kind)), data...)
+}
+
+// bpsCopy returns a copy action that moves the copy offset by off.
+func bpsCopy(kind, n, off int) []byte {
+	o := uint64(off) << 1
+	if off < 0 {
+		o = uint64(-off)<<1 | 1
+	}
+	return appendBPSNumber(bpsAction(kind, n), o)
+}
+
+func TestApplyBPS(t *testing.T) {
+	src, dst := []byte("abcdef"), []byte("abXYXYXYXdefcd")
+	p := bpsPatch(src, dst,
+		bpsAction(bpsSourceRead, 2),
+		bpsAction(bpsTargetRead, 2, 'X', 'Y'),
+		bpsCopy(bpsTargetCopy, 5, 2),  // "XYXYX", overlapping itself
+		bpsCopy(bpsSourceCopy, 3, 3),  // "def"
+		bpsCopy(bpsSourceCopy, 2, -4), // "cd"
+	)
+	got, err := ApplyBPS(src, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dst) {
+		t.Errorf("got %q, want %q", got, dst)
+	}
+
+	corrupt := append([]byte(nil), p...)
+	corrupt[len("BPS1")+3+2] ^= 1
+	for _, c := range []struct {
+		desc  string
+		src   []byte
+		patch []byte
+		err   string
+	}{
+		{"wrong ROM", []byte("abcdeg"), p, "different ROM"},
+		{"corrupt patch", src, corrupt, "corrupt"},
+		{"wrong result", src, bpsPatch(src, []byte("ab"), bpsAction(bpsTargetRead, 2, 'a', 'c')), "wrong ROM"},
+		{"read past source", src, bpsPatch(src, make([]byte, 8), bpsAction(bpsSourceRead, 8)), "past the end"},
+		{"short", src, []byte("BPS1"), "truncated"},
+	} {
+		_, err := ApplyBPS(c.src, c.patch)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want one containing %q", c.desc, err, c.err)
+		}
+	}
+}
+
+func TestApplyUnknown(t *testing.T) {
+	if _, err := Apply(nil, []byte("UPS1")); err != ErrUnknownFormat {
+		t.Errorf("got error %v, want %v", err, ErrUnknownFormat)
+	}
+}
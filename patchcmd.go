@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nf/nux/patch"
+)
+
+// patchMain implements "nux patch", which creates a patch that
+// transforms one ROM into another, for use with -patch.
+func patchMain(args []string) error {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	var (
+		outFlag    = flags.String("o", "", "write the patch to `file` (default standard output)")
+		formatFlag = flags.String("format", "", "write the patch in `format` \"ips\" or \"bps\" (default from the -o extension, or bps)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s patch [flags] <old.rom> <new.rom>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	files := parseInterspersed(flags, args)
+	if len(files) != 2 {
+		flags.Usage()
+	}
+
+	format := *formatFlag
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*outFlag)), ".")
+		if format != "ips" {
+			format = "bps"
+		}
+	}
+	var f patch.Format
+	switch format {
+	case "ips":
+		f = patch.IPS
+	case "bps":
+		f = patch.BPS
+	default:
+		return fmt.Errorf("unknown patch format %q", format)
+	}
+
+	src, err := readROM(files[0])
+	if err != nil {
+		return err
+	}
+	dst, err := readROM(files[1])
+	if err != nil {
+		return err
+	}
+	p, err := patch.Create(f, src, dst)
+	if err != nil {
+		return err
+	}
+	if *outFlag == "" {
+		_, err = os.Stdout.Write(p)
+		return err
+	}
+	return os.WriteFile(*outFlag, p, 0644)
+}
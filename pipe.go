@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nf/nux/varvara"
+)
+
+// pipeGraph is a set of Varvara instances whose consoles are connected
+// as described by -pipe flags. Each flag is a pipeline of stages
+// separated by "|", in which the console output of each stage is
+// delivered as console input to the next. A stage is either
+//
+//	[name=][cli:]program.rom [arg ...]
+//
+// which defines a new instance, running headless if "cli:" is given,
+// or the name of an instance defined earlier, so that several
+// pipelines may share instances to form a graph.
+type pipeGraph struct {
+	nodes []*pipeNode          // in order of definition
+	names map[string]*pipeNode // instances defined with a name
+}
+
+type pipeNode struct {
+	name string // as given, or derived from the ROM file name
+	file string
+	args []string
+	cli  bool
+
+	in, out []*pipeNode
+	input   *consolePipe // nil if in is empty
+}
+
+// add adds the instances and connections of a -pipe flag to g.
+func (g *pipeGraph) add(pipeline string) error {
+	var prev *pipeNode
+	for _, stage := range strings.Split(pipeline, "|") {
+		n, err := g.stage(strings.Fields(stage))
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			if prev == n {
+				return fmt.Errorf("%s is piped into itself", n.name)
+			}
+			if !contains(prev.out, n) {
+				prev.out = append(prev.out, n)
+				n.in = append(n.in, prev)
+			}
+		}
+		prev = n
+	}
+	return nil
+}
+
+// stage returns the instance described by the fields of a stage,
+// defining it if necessary.
+func (g *pipeGraph) stage(fields []string) (*pipeNode, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty stage")
+	}
+	if len(fields) == 1 {
+		if n, ok := g.names[fields[0]]; ok {
+			return n, nil
+		}
+	}
+	name, file, named := strings.Cut(fields[0], "=")
+	if !named {
+		file = name
+	}
+	file, cli := strings.CutPrefix(file, "cli:")
+	if file == "" {
+		return nil, fmt.Errorf("stage %q has no ROM file", strings.Join(fields, " "))
+	}
+	n := &pipeNode{name: name, file: file, args: fields[1:], cli: cli}
+	if named {
+		if name == "" {
+			return nil, fmt.Errorf("stage %q has an empty name", strings.Join(fields, " "))
+		}
+		if _, ok := g.names[name]; ok {
+			return nil, fmt.Errorf("instance %s defined twice", name)
+		}
+		if g.names == nil {
+			g.names = map[string]*pipeNode{}
+		}
+		g.names[name] = n
+	} else {
+		n.name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	g.nodes = append(g.nodes, n)
+	return n, nil
+}
+
+func contains(ns []*pipeNode, n *pipeNode) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// runPipes runs the instances of g concurrently, each with its own window
+// unless it is headless, until they have all exited. The first instance
+// reads standard input, unless it is piped from another, and instances
+// that are not piped into another write to standard output.
+// It returns the first error of a display, or else the first non-zero
+// exit code, in order of definition.
+func runPipes(g *pipeGraph, opts *options) (int, error) {
+	var (
+		roms     = make([][]byte, len(g.nodes))
+		nodeOpts = make([]options, len(g.nodes))
+	)
+	for i, n := range g.nodes {
+		nodeOpts[i] = *opts
+		nodeOpts[i].args = n.args
+		nodeOpts[i].title = "nux: " + n.name
+		rom, err := nodeOpts[i].load(n.file)
+		if err != nil {
+			return 0, err
+		}
+		roms[i] = rom
+		if len(n.in) > 0 {
+			n.input = newConsolePipe(len(n.in))
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		codes   = make([]int, len(g.nodes))
+		errs    = make([]error, len(g.nodes))
+		windows = false
+	)
+	for i, n := range g.nodes {
+		o := nodeOpts[i]
+		if n.cli {
+			o.display = varvara.NoDisplay
+		}
+		windows = windows || o.display != varvara.NoDisplay
+		switch {
+		case n.input != nil:
+			o.stdin = n.input
+		case i > 0:
+			o.stdin = strings.NewReader("")
+		}
+		if len(n.out) > 0 {
+			var out fanOut
+			for _, m := range n.out {
+				out = append(out, m.input)
+			}
+			o.stdout = out
+		}
+		r := o.newRunner(false, nil)
+
+		i, n := i, n
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i], errs[i] = r.Run(roms[i])
+			for _, m := range n.out {
+				m.input.closeWrite()
+			}
+			if n.input != nil {
+				n.input.closeRead()
+			}
+		}()
+	}
+	if windows {
+		varvara.Main(wg.Wait)
+	} else {
+		wg.Wait()
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return codes[i], fmt.Errorf("%s: %v", g.nodes[i].name, err)
+		}
+	}
+	for _, code := range codes {
+		if code != 0 {
+			return code, nil
+		}
+	}
+	return 0, nil
+}
+
+// consolePipe carries the console output of one or more instances to the
+// console input of another. Writes never block, so that an instance is
+// not held up by one that reads its input slowly or not at all.
+// Reads return io.EOF once every writer has closed the pipe.
+type consolePipe struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	buf     []byte
+	writers int  // the number of writers yet to close the pipe
+	closed  bool // the reader has exited, so writes are discarded
+}
+
+func newConsolePipe(writers int) *consolePipe {
+	p := &consolePipe{writers: writers}
+	p.cond.L = &p.mu
+	return p
+}
+
+func (p *consolePipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.buf = append(p.buf, b...)
+		p.cond.Signal()
+	}
+	return len(b), nil
+}
+
+func (p *consolePipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && p.writers > 0 {
+		p.cond.Wait()
+	}
+	if len(p.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// closeWrite is called by each writer once it has exited.
+func (p *consolePipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writers--
+	p.cond.Signal()
+}
+
+// closeRead is called by the reader once it has exited.
+func (p *consolePipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.buf = nil
+}
+
+// fanOut writes console output to each of its pipes.
+type fanOut []*consolePipe
+
+func (f fanOut) Write(b []byte) (int, error) {
+	for _, p := range f {
+		p.Write(b)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nf/nux/varvara"
+)
+
+// describe returns the instances of g, and the connections between them.
+func describe(g *pipeGraph) (nodes, edges []string) {
+	for _, n := range g.nodes {
+		d := n.name + "=" + n.file
+		if n.cli {
+			d = n.name + "=cli:" + n.file
+		}
+		nodes = append(nodes, strings.Join(append([]string{d}, n.args...), " "))
+		for _, m := range n.out {
+			edges = append(edges, n.name+">"+m.name)
+		}
+	}
+	return nodes, edges
+}
+
+func TestPipeGraph(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		pipelines []string
+		nodes     []string
+		edges     []string
+		err       string
+	}{
+		{
+			name:      "single",
+			pipelines: []string{"a.rom"},
+			nodes:     []string{"a=a.rom"},
+		},
+		{
+			name:      "chain",
+			pipelines: []string{"dir/a.rom x y | cli:b.tal | c.rom"},
+			nodes:     []string{"a=dir/a.rom x y", "b=cli:b.tal", "c=c.rom"},
+			edges:     []string{"a>b", "b>c"},
+		},
+		{
+			name:      "named reuse",
+			pipelines: []string{"src=a.rom | one=b.rom", "src | two=cli:b.rom arg"},
+			nodes:     []string{"src=a.rom", "one=b.rom", "two=cli:b.rom arg"},
+			edges:     []string{"src>one", "src>two"},
+		},
+		{
+			name:      "fan in",
+			pipelines: []string{"x=a.rom | sink=c.rom", "y=b.rom | sink"},
+			nodes:     []string{"x=a.rom", "sink=c.rom", "y=b.rom"},
+			edges:     []string{"x>sink", "y>sink"},
+		},
+		{
+			name:      "cycle",
+			pipelines: []string{"a=a.rom | b=b.rom", "b | a"},
+			nodes:     []string{"a=a.rom", "b=b.rom"},
+			edges:     []string{"a>b", "b>a"},
+		},
+		{
+			name:      "repeated connection",
+			pipelines: []string{"a=a.rom | b=b.rom", "a | b"},
+			nodes:     []string{"a=a.rom", "b=b.rom"},
+			edges:     []string{"a>b"},
+		},
+		{
+			// A stage that is the name of an instance is only
+			// taken as a file if it has arguments.
+			name:      "name shadows file",
+			pipelines: []string{"a.rom=x.rom", "a.rom arg | a.rom"},
+			nodes:     []string{"a.rom=x.rom", "a=a.rom arg"},
+			edges:     []string{"a>a.rom"},
+		},
+		{
+			name:      "self",
+			pipelines: []string{"a=a.rom | a"},
+			err:       "a is piped into itself",
+		},
+		{
+			name:      "unnamed twice",
+			pipelines: []string{"a.rom | a.rom"},
+			nodes:     []string{"a=a.rom", "a=a.rom"},
+			edges:     []string{"a>a"},
+		},
+		{
+			name:      "defined twice",
+			pipelines: []string{"a=a.rom", "a=b.rom"},
+			err:       "instance a defined twice",
+		},
+		{
+			name:      "empty stage",
+			pipelines: []string{"a.rom | | b.rom"},
+			err:       "empty stage",
+		},
+		{
+			name:      "empty name",
+			pipelines: []string{"=a.rom"},
+			err:       "empty name",
+		},
+		{
+			name:      "no file",
+			pipelines: []string{"a=cli:"},
+			err:       "has no ROM file",
+		},
+	} {
+		var (
+			g   pipeGraph
+			err error
+		)
+		for _, p := range test.pipelines {
+			if err = g.add(p); err != nil {
+				break
+			}
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		nodes, edges := describe(&g)
+		if !equalStrings(nodes, test.nodes) {
+			t.Errorf("%s: instances = %q, want %q", test.name, nodes, test.nodes)
+		}
+		if !equalStrings(edges, test.edges) {
+			t.Errorf("%s: connections = %q, want %q", test.name, edges, test.edges)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConsolePipe(t *testing.T) {
+	p := newConsolePipe(2)
+	io.WriteString(p, "ab")
+	fanOut{p}.Write([]byte("c"))
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(p)
+		done <- b
+	}()
+	p.closeWrite()
+	io.WriteString(p, "d")
+	select {
+	case b := <-done:
+		t.Fatalf("read ended with %q before the last writer closed", b)
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.closeWrite()
+	if got, want := string(<-done), "abcd"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+
+	// Once the reader has exited, writes are discarded.
+	p = newConsolePipe(1)
+	p.closeRead()
+	if n, err := io.WriteString(p, "x"); n != 1 || err != nil {
+		t.Errorf("write after closeRead = %d, %v; want 1, nil", n, err)
+	}
+	p.closeWrite()
+	if b, err := io.ReadAll(p); len(b) != 0 || err != nil {
+		t.Errorf("read after closeRead = %q, %v; want nothing", b, err)
+	}
+}
+
+// catROM copies its console input to its console output,
+// ignoring the end of input.
+var catROM = []byte{
+	0xa0, 0x01, 0x07, // LIT2 0107
+	0x80, 0x10, 0x37, // LIT 10 DEO2: console vector
+	0x00,             // BRK
+	0x80, 0x17, 0x16, // LIT 17 DEI: type
+	0x80, 0x04, 0x08, // LIT 04 EQU
+	0x80, 0x06, 0x0d, // LIT 06 JCN: to BRK at the end of input
+	0x80, 0x12, 0x16, // LIT 12 DEI: read
+	0x80, 0x18, 0x17, // LIT 18 DEO: write
+	0x00, // BRK
+}
+
+// syncBuffer is a bytes.Buffer that may be written concurrently.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func TestRunPipes(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "cat.rom")
+	if err := os.WriteFile(rom, catROM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The end of the first instance's input reaches the others through
+	// the pipes between them, so that all of them exit.
+	for _, test := range []struct {
+		name      string
+		pipelines []string
+		want      string // sorted, as fanned out output interleaves
+	}{
+		{"two stages", []string{"cli:cat.rom | cli:cat.rom"}, "ehllo"},
+		{"fan out", []string{"src=cli:cat.rom | cli:cat.rom", "src | cli:cat.rom"}, "eehhlllloo"},
+	} {
+		var g pipeGraph
+		for _, p := range test.pipelines {
+			if err := g.add(strings.ReplaceAll(p, "cat.rom", rom)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var out syncBuffer
+		opts := &options{
+			display: varvara.NoDisplay,
+			stdin:   strings.NewReader("hello"),
+			stdout:  &out,
+			fs:      &varvara.MemFS{},
+		}
+		done := make(chan error)
+		go func() {
+			_, err := runPipes(&g, opts)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: pipeline did not exit", test.name)
+		}
+		got := []byte(out.b.String())
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if string(got) != test.want {
+			t.Errorf("%s: sorted output = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// rawTerminal puts standard input, which must be a terminal, into raw
+// mode, so that each byte typed is read immediately and without echo.
+// It returns a reader for standard input that calls interrupt when
+// Ctrl-C is typed, and a function that restores the terminal's
+// previous mode.
+func rawTerminal(interrupt func()) (stdin io.Reader, restore func(), err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, nil, errNotTerminal
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	restore = func() {
+		once.Do(func() { term.Restore(fd, state) })
+	}
+	return &rawReader{r: os.Stdin, interrupt: interrupt}, restore, nil
+}
+
+var errNotTerminal = errors.New("standard input is not a terminal")
+
+// rawReader reads from a terminal in raw mode,
+// which no longer turns Ctrl-C into an interrupt.
+type rawReader struct {
+	r         io.Reader
+	interrupt func()
+}
+
+func (r *rawReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if i := bytes.IndexByte(p[:n], 0x03); i >= 0 {
+		r.interrupt()
+		return i, err // drop the input typed after Ctrl-C
+	}
+	return n, err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// socketConsole connects the Console device to a network socket, either
+// by listening for connections or by dialing out. It serves one
+// connection at a time, and makes a new one when the current one closes.
+// Console output written while there is no connection is discarded.
+type socketConsole struct {
+	network, addr string
+	ln            net.Listener // nil if dialing
+
+	mu     sync.Mutex
+	cond   sync.Cond
+	conn   net.Conn // nil while disconnected
+	closed bool
+	done   chan struct{} // closed by Close
+}
+
+// redialDelay is how long to wait before dialing again
+// after a failed or closed connection.
+const redialDelay = time.Second
+
+// newSocketConsole returns a socketConsole that listens on or dials the
+// given address, which is a TCP address ("host:port" or "tcp:host:port")
+// or the path of a Unix socket ("unix:path").
+func newSocketConsole(addr string, listen bool) (*socketConsole, error) {
+	network := "tcp"
+	if n, a, ok := strings.Cut(addr, ":"); ok && (n == "tcp" || n == "unix") {
+		network, addr = n, a
+	}
+	s := &socketConsole{network: network, addr: addr, done: make(chan struct{})}
+	s.cond.L = &s.mu
+	if listen {
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.ln = ln
+	}
+	go s.connect()
+	return s, nil
+}
+
+// Close closes the current connection and stops making new ones,
+// removing the socket file of a Unix socket.
+// Reads after Close return io.EOF.
+func (s *socketConsole) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// connect makes a new connection whenever there is none,
+// until s is closed.
+func (s *socketConsole) connect() {
+	for first := true; ; first = false {
+		s.mu.Lock()
+		for s.conn != nil && !s.closed {
+			s.cond.Wait()
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+
+		var (
+			c   net.Conn
+			err error
+		)
+		if s.ln != nil {
+			c, err = s.ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("console: %v", err)
+				}
+				return
+			}
+		} else {
+			if !first {
+				// Don't redial too often if the server
+				// is down or closes each connection at once.
+				select {
+				case <-time.After(redialDelay):
+				case <-s.done:
+					return
+				}
+			}
+			c, err = net.Dial(s.network, s.addr)
+			if err != nil {
+				continue
+			}
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		log.Printf("console: connected to %s", c.RemoteAddr())
+		s.conn = c
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// current waits for and returns the current connection,
+// or returns nil once s is closed.
+func (s *socketConsole) current() net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.conn == nil && !s.closed {
+		s.cond.Wait()
+	}
+	return s.conn
+}
+
+// drop closes c, if it is the current connection,
+// so that a new connection is made.
+func (s *socketConsole) drop(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != c {
+		return
+	}
+	c.Close()
+	s.conn = nil
+	s.cond.Broadcast()
+	log.Printf("console: disconnected")
+}
+
+// Read reads console input from the current connection,
+// waiting for a new connection if there is none.
+func (s *socketConsole) Read(p []byte) (int, error) {
+	for {
+		c := s.current()
+		if c == nil {
+			return 0, io.EOF
+		}
+		n, err := c.Read(p)
+		if err != nil {
+			s.drop(c)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Write writes console output to the current connection, if any.
+func (s *socketConsole) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c != nil {
+		if _, err := c.Write(p); err != nil {
+			s.drop(c)
+		}
+	}
+	return len(p), nil
+}
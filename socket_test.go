@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readString reads from r until it has n bytes, failing t on error.
+func readString(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSocketConsoleListen(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sock")
+	for _, addr := range []string{"127.0.0.1:0", "unix:" + sock} {
+		s, err := newSocketConsole(addr, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network, dial := s.ln.Addr().Network(), s.ln.Addr().String()
+
+		// Each connection in turn is the console.
+		for _, msg := range []string{"first", "second"} {
+			c, err := net.Dial(network, dial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(c, msg)
+			if got := readString(t, s, len(msg)); got != msg {
+				t.Errorf("%s: console input = %q, want %q", addr, got, msg)
+			}
+			io.WriteString(s, "ok")
+			if got := readString(t, c, 2); got != "ok" {
+				t.Errorf("%s: client read %q, want %q", addr, got, "ok")
+			}
+			c.Close()
+		}
+
+		read := make(chan error)
+		go func() {
+			_, err := s.Read(make([]byte, 1))
+			read <- err
+		}()
+		s.Close()
+		select {
+		case err := <-read:
+			if err != io.EOF {
+				t.Errorf("%s: Read after Close = %v, want EOF", addr, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Read did not return after Close", addr)
+		}
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("Unix socket remains after Close: %v", err)
+	}
+}
+
+func TestSocketConsoleDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accept := func() net.Conn {
+		t.Helper()
+		ln.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	s, err := newSocketConsole("tcp:"+ln.Addr().String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := accept()
+	io.WriteString(c, "hi")
+	if got := readString(t, s, 2); got != "hi" {
+		t.Errorf("console input = %q, want %q", got, "hi")
+	}
+	io.WriteString(s, "ok")
+	if got := readString(t, c, 2); got != "ok" {
+		t.Errorf("server read %q, want %q", got, "ok")
+	}
+
+	// The console redials once the server closes the connection.
+	c.Close()
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 5)
+		n, _ := io.ReadFull(s, b)
+		read <- string(b[:n])
+	}()
+	c = accept()
+	io.WriteString(c, "again")
+	if got := <-read; got != "again" {
+		t.Errorf("after redial, console input = %q, want %q", got, "again")
+	}
+
+	// Once closed, the console neither keeps its connection nor redials.
+	s.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("server read after Close = %d, %v; want EOF", n, err)
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(2 * redialDelay))
+	if c, err := ln.Accept(); err == nil {
+		c.Close()
+		t.Error("console redialed after Close")
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/nf/nux/varvara"
 )
 
 type symbol struct {
@@ -76,6 +79,11 @@ func parseSymbols(symFile string) (*symbols, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parseSymbolData(b)
+}
+
+// parseSymbolData parses the contents of a symbol file.
+func parseSymbolData(b []byte) (*symbols, error) {
 	var ss []symbol
 	for len(b) > 0 {
 		if len(b) < 3 {
@@ -103,6 +111,22 @@ func parseSymbols(symFile string) (*symbols, error) {
 	return &syms, nil
 }
 
+// logMisuse logs the misuse of a device, with the label of the code
+// responsible if s is not nil and has one.
+func (s *symbols) logMisuse(m varvara.Misuse) {
+	if s != nil {
+		ss := s.forAddr(m.Addr)
+		if len(ss) == 0 {
+			ss = s.beforeAddr(m.Addr)
+		}
+		if len(ss) > 0 {
+			log.Printf("%v in %s+%d", m, ss[0].label, m.Addr-ss[0].addr)
+			return
+		}
+	}
+	log.Print(m)
+}
+
 func (sym *symbols) resolve(t string) []symbol {
 	if i, err := strconv.ParseUint(t, 16, 16); err == nil {
 		return []symbol{{addr: uint16(i)}}
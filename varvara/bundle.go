@@ -0,0 +1,127 @@
+package varvara
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// A Bundle is a ROM packaged with the files it needs, for distribution
+// as a single file. Bundles are zip archives, conventionally with the
+// extension ".uxz", containing:
+//
+//   - the ROM, at the top level of the archive;
+//   - optionally, its symbol file, with ".sym" appended to the ROM's name;
+//   - optionally, a metadata file named "bundle.txt";
+//   - optionally, asset files in the "assets" directory, which are
+//     given to the File device as the root of its file system.
+//
+// The metadata file holds "key: value" lines, and blank lines or lines
+// beginning with "#", which are ignored. These keys are defined:
+//
+//	rom:  the name of the ROM, if the archive has more than one
+//	name: the name of the program, such as for window titles
+//	args: the command-line arguments passed to the program by default,
+//	      separated by spaces
+//
+// Other keys may be used to describe the program, such as "author".
+type Bundle struct {
+	ROM     []byte
+	Symbols []byte // the symbol file, or nil if there is none
+	Meta    map[string]string
+	Assets  fs.FS
+}
+
+// Name returns the name of the program in b.
+func (b *Bundle) Name() string { return b.Meta["name"] }
+
+// Args returns the command-line arguments passed to the program by default.
+func (b *Bundle) Args() []string { return strings.Fields(b.Meta["args"]) }
+
+// OpenBundle reads the named bundle file into memory.
+func OpenBundle(name string) (*Bundle, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ReadBundle(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// ReadBundle reads a bundle from the zip archive in r,
+// which has the given size.
+func ReadBundle(r io.ReaderAt, size int64) (*Bundle, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Meta: map[string]string{}}
+
+	if data, err := fs.ReadFile(z, "bundle.txt"); err == nil {
+		if b.Meta, err = parseBundleMeta(data); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	rom := b.Meta["rom"]
+	if rom == "" {
+		des, err := fs.ReadDir(z, ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, de := range des {
+			if de.IsDir() || path.Ext(de.Name()) != ".rom" {
+				continue
+			}
+			if rom != "" {
+				return nil, errors.New(`more than one ROM; set "rom" in bundle.txt`)
+			}
+			rom = de.Name()
+		}
+		if rom == "" {
+			return nil, errors.New("no ROM")
+		}
+	}
+	if b.ROM, err = fs.ReadFile(z, rom); err != nil {
+		return nil, err
+	}
+	if b.Symbols, err = fs.ReadFile(z, rom+".sym"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if fi, err := fs.Stat(z, "assets"); err == nil && fi.IsDir() {
+		b.Assets, _ = fs.Sub(z, "assets")
+	} else {
+		b.Assets = &MemFS{}
+	}
+	return b, nil
+}
+
+func parseBundleMeta(data []byte) (map[string]string, error) {
+	meta := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("bundle.txt:%d: want key: value, got %q", n, line)
+		}
+		meta[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return meta, s.Err()
+}
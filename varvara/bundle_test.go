@@ -0,0 +1,97 @@
+package varvara
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+// makeZip returns a zip archive holding the given files.
+func makeZip(t *testing.T, files map[string]string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestReadBundle(t *testing.T) {
+	z := makeZip(t, map[string]string{
+		"game.rom":         "rom",
+		"game.rom.sym":     "sym",
+		"bundle.txt":       "# A game.\nname: Game\n\nargs:  -level 2 \nauthor: someone\n",
+		"assets/level.txt": "level",
+		"assets/gfx/a.chr": "chr",
+	})
+	b, err := ReadBundle(z, z.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b.ROM); got != "rom" {
+		t.Errorf("ROM = %q, want %q", got, "rom")
+	}
+	if got := string(b.Symbols); got != "sym" {
+		t.Errorf("Symbols = %q, want %q", got, "sym")
+	}
+	if got := b.Name(); got != "Game" {
+		t.Errorf("Name() = %q, want %q", got, "Game")
+	}
+	if got, want := b.Args(), []string{"-level", "2"}; !equalStrings(got, want) {
+		t.Errorf("Args() = %q, want %q", got, want)
+	}
+	if got := b.Meta["author"]; got != "someone" {
+		t.Errorf(`Meta["author"] = %q, want %q`, got, "someone")
+	}
+	for name, want := range map[string]string{"level.txt": "level", "gfx/a.chr": "chr"} {
+		got, err := fs.ReadFile(b.Assets, name)
+		if err != nil || string(got) != want {
+			t.Errorf("reading asset %s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := fs.Stat(b.Assets, "bundle.txt"); err == nil {
+		t.Error("bundle.txt is an asset")
+	}
+}
+
+func TestReadBundleROM(t *testing.T) {
+	for _, c := range []struct {
+		desc  string
+		files map[string]string
+		rom   string // or empty if an error is expected
+	}{
+		{"no metadata", map[string]string{"a.rom": "a"}, "a"},
+		{"no ROM", map[string]string{"assets/a.rom": "a"}, ""},
+		{"two ROMs", map[string]string{"a.rom": "a", "b.rom": "b"}, ""},
+		{"ROM named", map[string]string{"a.rom": "a", "b.rom": "b", "bundle.txt": "rom: b.rom"}, "b"},
+		{"ROM named but missing", map[string]string{"a.rom": "a", "bundle.txt": "rom: b.rom"}, ""},
+		{"bad metadata", map[string]string{"a.rom": "a", "bundle.txt": "name"}, ""},
+	} {
+		z := makeZip(t, c.files)
+		b, err := ReadBundle(z, z.Size())
+		switch {
+		case c.rom == "" && err == nil:
+			t.Errorf("%s: got ROM %q, want error", c.desc, b.ROM)
+		case c.rom != "" && err != nil:
+			t.Errorf("%s: %v", c.desc, err)
+		case c.rom != "" && string(b.ROM) != c.rom:
+			t.Errorf("%s: got ROM %q, want %q", c.desc, b.ROM, c.rom)
+		case c.rom != "":
+			if b.Symbols != nil {
+				t.Errorf("%s: got symbols %q, want none", c.desc, b.Symbols)
+			}
+			if _, err := fs.ReadDir(b.Assets, "."); err != nil {
+				t.Errorf("%s: reading empty assets: %v", c.desc, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package varvara
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of the time reported by the Datetime device.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock reports the current time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+// FixedClock always reports the same time.
+type FixedClock struct {
+	Time time.Time
+}
+
+func (c FixedClock) Now() time.Time { return c.Time }
+
+// OffsetClock reports the current time plus a fixed offset.
+type OffsetClock struct {
+	Offset time.Duration
+}
+
+// ClockFrom returns an OffsetClock that starts at t.
+func ClockFrom(t time.Time) OffsetClock {
+	return OffsetClock{Offset: time.Until(t)}
+}
+
+func (c OffsetClock) Now() time.Time { return time.Now().Add(c.Offset) }
+
+// FrameClock reports a time that starts at Start and advances by
+// one sixtieth of a second with each screen frame, regardless of
+// how long each frame actually takes.
+type FrameClock struct {
+	Start  time.Time
+	frames uint64
+}
+
+func (c *FrameClock) Now() time.Time {
+	return c.Start.Add(time.Duration(c.frames) * time.Second / 60)
+}
+
+func (c *FrameClock) tick() { c.frames++ }
+
+// timeSource holds the clock and time zone used by Datetime devices.
+// It may be shared by several Varvara instances and changed while
+// they run.
+type timeSource struct {
+	mu    sync.Mutex
+	clock Clock
+	loc   *time.Location
+}
+
+func newTimeSource() *timeSource {
+	return &timeSource{clock: RealClock{}, loc: time.Local}
+}
+
+func (s *timeSource) set(c Clock) {
+	s.mu.Lock()
+	s.clock = c
+	s.mu.Unlock()
+}
+
+func (s *timeSource) setLocation(loc *time.Location) {
+	s.mu.Lock()
+	s.loc = loc
+	s.mu.Unlock()
+}
+
+// now returns the current time of the clock in the time zone.
+func (s *timeSource) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now().In(s.loc)
+}
+
+// tick advances the clock by a frame, if it is frame-locked.
+func (s *timeSource) tick() {
+	s.mu.Lock()
+	if c, ok := s.clock.(interface{ tick() }); ok {
+		c.tick()
+	}
+	s.mu.Unlock()
+}
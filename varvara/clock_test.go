@@ -0,0 +1,84 @@
+package varvara
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nf/nux/uxn"
+)
+
+func TestDatetimeClock(t *testing.T) {
+	var (
+		v   = newTestVarvara(t)
+		loc = time.FixedZone("UTC+2", 2*60*60)
+		at  = time.Date(2024, time.February, 29, 23, 30, 15, 0, time.UTC)
+	)
+	v.time.src.set(FixedClock{Time: at})
+	v.time.src.setLocation(loc)
+
+	// In UTC+2, the fixed time is 1 March.
+	for p, want := range []byte{
+		0x07, 0xe8, // year 2024
+		2,        // March
+		1,        // day
+		1,        // hour
+		30,       // minute
+		15,       // second
+		5,        // Friday
+		0x00, 60, // day of year
+		0, // not DST
+	} {
+		if got := v.In(0xc0 | byte(p)); got != want {
+			t.Errorf("port %x = %d, want %d", p, got, want)
+		}
+	}
+}
+
+func TestFrameClock(t *testing.T) {
+	var (
+		v     = newTestVarvara(t)
+		start = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	)
+	v.time.src.set(&FrameClock{Start: start})
+	v.time.src.setLocation(time.UTC)
+	for i := 0; i < 150; i++ {
+		v.nextFrame()
+	}
+	want := start.Add(2500 * time.Millisecond)
+	if got := v.time.src.now(); !got.Equal(want) {
+		t.Errorf("after 150 frames, now = %v, want %v", got, want)
+	}
+	if got := v.In(0xc6); got != 2 {
+		t.Errorf("seconds = %d, want 2", got)
+	}
+}
+
+func TestFrameClockRun(t *testing.T) {
+	// The screen vector prints the current second.
+	handler := cat(
+		[]byte{byte(uxn.LIT), 0xc6, byte(uxn.DEI), byte(uxn.LIT), 0x18, byte(uxn.DEO)},
+		[]byte{byte(uxn.BRK)},
+	)
+	rom := assemble(handler, deo2(0x20, spriteAddr))
+
+	run := func() string {
+		var out bytes.Buffer
+		r := NewRunner(NoDisplay, false, nil)
+		r.SetOutput(&out)
+		r.SetClock(&FrameClock{Start: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)})
+		r.SetLocation(time.UTC)
+		r.SetReplayInput(&InputRecording{Frames: 120})
+		r.Run(rom)
+		return out.String()
+	}
+	got := run()
+	want := strings.Repeat("\x00", 59) + strings.Repeat("\x01", 60) + "\x02"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if again := run(); again != got {
+		t.Errorf("second run output = %q, want %q", again, got)
+	}
+}
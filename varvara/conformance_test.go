@@ -0,0 +1,216 @@
+package varvara
+
+import (
+	"io"
+	"testing"
+
+	"github.com/nf/nux/uxn"
+)
+
+// The test ROMs are assembled from these helpers. Each returns the
+// bytecode for a short sequence of instructions.
+
+// deo writes the byte v to the given device port.
+func deo(port, v byte) []byte {
+	return []byte{byte(uxn.LIT2), v, port, byte(uxn.DEO)}
+}
+
+// deo2 writes the short v to the given device port.
+func deo2(port byte, v uint16) []byte {
+	return []byte{byte(uxn.LIT2), byte(v >> 8), byte(v), byte(uxn.LIT), port, byte(uxn.DEO2)}
+}
+
+// spriteAddr is the address at which assemble places sprite data.
+const spriteAddr = 0x1000
+
+// newTestVarvara returns a Varvara with no program, which discards its
+// console output and is closed when the test finishes.
+func newTestVarvara(t *testing.T) *Varvara {
+	t.Helper()
+	v := New(nil, func(*uxn.Machine, StateKind) {}, io.Discard, io.Discard)
+	t.Cleanup(v.Close)
+	return v
+}
+
+// assemble concatenates the given code, terminates it with BRK, and
+// places the given sprite data at spriteAddr.
+func assemble(sprites []byte, code ...[]byte) []byte {
+	var rom []byte
+	for _, c := range code {
+		rom = append(rom, c...)
+	}
+	rom = append(rom, byte(uxn.BRK))
+	if len(rom) > spriteAddr-0x100 {
+		panic("test ROM code too long")
+	}
+	if len(sprites) > 0 {
+		rom = append(rom, make([]byte, spriteAddr-0x100-len(rom))...)
+		rom = append(rom, sprites...)
+	}
+	return rom
+}
+
+// Screen device helpers.
+
+func theme() []byte {
+	var b []byte
+	b = append(b, deo2(0x08, 0x0f5a)...)
+	b = append(b, deo2(0x0a, 0x05fa)...)
+	b = append(b, deo2(0x0c, 0x0a5f)...)
+	return b
+}
+
+func screenSize(w, h uint16) []byte { return append(deo2(0x22, w), deo2(0x24, h)...) }
+func screenXY(x, y int16) []byte    { return append(deo2(0x28, uint16(x)), deo2(0x2a, uint16(y))...) }
+func screenAuto(b byte) []byte      { return deo(0x26, b) }
+func screenAddr(a uint16) []byte    { return deo2(0x2c, a) }
+func pixel(op byte) []byte          { return deo(0x2e, op) }
+func sprite(op byte) []byte         { return deo(0x2f, op) }
+
+// repeat returns n copies of the code generated by f(i) for i in [0, n).
+func repeat(n int, f func(i int) []byte) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, f(i)...)
+	}
+	return b
+}
+
+func cat(bs ...[]byte) []byte {
+	var b []byte
+	for _, c := range bs {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// runResetVector loads rom into a new Varvara and runs it until it
+// reaches the first BRK.
+func runResetVector(t *testing.T, rom []byte) *Varvara {
+	t.Helper()
+	v := New(rom, func(*uxn.Machine, StateKind) {}, io.Discard, io.Discard)
+	for i := 0; ; i++ {
+		if i > 1e6 {
+			t.Fatal("reset vector did not terminate")
+		}
+		err := v.m.Exec()
+		if err == uxn.ErrBRK {
+			break
+		}
+		if h, ok := err.(uxn.HaltError); ok && h.HaltCode == uxn.Halt {
+			break
+		} else if err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+	}
+	return v
+}
+
+func TestScreenConformance(t *testing.T) {
+	sprites := []byte{
+		// 0x1000: 1bpp arrow
+		0x10, 0x30, 0x7f, 0xff, 0x7f, 0x30, 0x10, 0x00,
+		// 0x1008: 2bpp ring, as 1bpp also a ring
+		0x3c, 0x42, 0x81, 0x81, 0x81, 0x81, 0x42, 0x3c,
+		0x00, 0x3c, 0x7e, 0x66, 0x66, 0x7e, 0x3c, 0x00,
+		// 0x1018: 1bpp checker
+		0xaa, 0x55, 0xaa, 0x55, 0xaa, 0x55, 0xaa, 0x55,
+	}
+	for _, c := range []struct {
+		name string
+		w, h uint16
+		code []byte
+	}{
+		{"pixel", 0x20, 0x20, cat(
+			// Background pixels of each color, and foreground pixels
+			// of each color drawn over a background row.
+			repeat(4, func(i int) []byte {
+				return cat(screenXY(int16(4+i*2), 4), pixel(byte(i)))
+			}),
+			repeat(8, func(i int) []byte {
+				return cat(screenXY(int16(4+i), 8), pixel(0x02))
+			}),
+			repeat(4, func(i int) []byte {
+				return cat(screenXY(int16(4+i*2), 8), pixel(0x40|byte(i)))
+			}),
+			// Offscreen pixels are ignored.
+			screenXY(-1, 4), pixel(0x01),
+			screenXY(0x20, 4), pixel(0x01),
+		)},
+		{"fill", 0x20, 0x20, cat(
+			// Each quadrant, as selected by the flip bits.
+			screenXY(0x10, 0x10), pixel(0x81),
+			screenXY(0x0f, 0x10), pixel(0x92),
+			screenXY(0x10, 0x0f), pixel(0xa3),
+			screenXY(0x0f, 0x0f), pixel(0xb0),
+			// A foreground fill over part of them, and a transparent
+			// foreground fill that clears part of that.
+			screenXY(0x18, 0x18), pixel(0xc2),
+			screenXY(0x1c, 0x1c), pixel(0xc0),
+		)},
+		{"sprite", 0x20, 0x20, cat(
+			screenAddr(spriteAddr), screenXY(2, 2), sprite(0x01),
+			screenAddr(spriteAddr+8), screenXY(12, 2), sprite(0x81),
+			screenAddr(spriteAddr+8), screenXY(22, 2), sprite(0x05),
+			screenAddr(spriteAddr), screenXY(2, 12), sprite(0x11),
+			screenAddr(spriteAddr), screenXY(12, 12), sprite(0x21),
+			screenAddr(spriteAddr), screenXY(22, 12), sprite(0x31),
+			// Partly offscreen.
+			screenAddr(spriteAddr+8), screenXY(-4, 24), sprite(0x81),
+			screenAddr(spriteAddr+8), screenXY(28, 24), sprite(0x81),
+		)},
+		{"auto", 0x40, 0x30, cat(
+			// Auto x and auto y pixels draw lines.
+			screenAuto(0x01), screenXY(2, 2), repeat(12, func(int) []byte { return pixel(0x01) }),
+			screenAuto(0x02), screenXY(2, 4), repeat(12, func(int) []byte { return pixel(0x02) }),
+			screenAuto(0x03), screenXY(4, 4), repeat(12, func(int) []byte { return pixel(0x03) }),
+			// Auto x sprites, each advancing x by 8.
+			screenAuto(0x01), screenAddr(spriteAddr), screenXY(20, 2),
+			repeat(3, func(int) []byte { return sprite(0x01) }),
+			// Auto x and addr with a repeat count draws a column of
+			// consecutive sprites.
+			screenAuto(0x15), screenAddr(spriteAddr), screenXY(20, 12), sprite(0x02),
+			// Auto y with a repeat count, flipped, draws a row
+			// leftwards.
+			screenAuto(0x22), screenAddr(spriteAddr), screenXY(52, 32), sprite(0x13),
+		)},
+		{"blend", 0x40, 0x20, cat(
+			// A striped background reveals the blending behavior of
+			// pixels of color zero.
+			repeat(16, func(i int) []byte {
+				return cat(screenXY(0, int16(i*2)), pixel(0x80|byte(i%4)))
+			}),
+			// Every blending mode in each layer.
+			screenAddr(spriteAddr+8),
+			repeat(16, func(i int) []byte {
+				x, y := int16(i%8*8), int16(i/8*8)
+				return cat(
+					screenXY(x, y), sprite(0x80|byte(i)),
+					screenXY(x, y+16), sprite(0xc0|byte(i)),
+				)
+			}),
+		)},
+		{"layers", 0x20, 0x20, cat(
+			// A background checkerboard, with foreground sprites drawn
+			// over it. Blending mode 5 leaves the foreground untouched
+			// where the sprite has color zero, while mode 0 draws both
+			// colors transparently and so erases the sprite beneath it.
+			screenAddr(spriteAddr+0x18),
+			repeat(16, func(i int) []byte {
+				return cat(screenXY(int16(i%4*8), int16(i/4*8)), sprite(0x01))
+			}),
+			screenAddr(spriteAddr),
+			screenXY(4, 4), sprite(0x42),
+			screenXY(4, 4), sprite(0x45),
+			screenXY(16, 4), sprite(0x4a),
+			screenXY(4, 16), sprite(0x42),
+			screenXY(4, 16), sprite(0x40),
+			screenXY(16, 16), sprite(0x4f),
+		)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			v := runResetVector(t, assemble(sprites, theme(), screenSize(c.w, c.h), c.code))
+			checkGolden(t, "screen-"+c.name, v.scr.image())
+		})
+	}
+}
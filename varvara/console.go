@@ -8,55 +8,299 @@ import (
 type Console struct {
 	Ready <-chan bool
 
-	mem   deviceMem
-	input <-chan byte
+	mem     deviceMem
+	main    []byte // view of main memory
+	input   <-chan consoleInput
+	send    chan<- consoleInput // the other ends of input and Ready
+	ready   chan<- bool
+	ended   bool           // the end of the input has been delivered
+	reading bool           // input is being read from in
+	args    []consoleInput // yet to be delivered
 
 	in       io.Reader
 	out, err io.Writer
+	typed    *textBuffer // typed text, if read instead of stdin
+
+	record func(consoleInput) // if non-nil, called with each input delivered
+	done   chan struct{}      // closed by close
+
+	// Set for a terminal in raw mode.
+	arrows *Controller // receives arrow keys from the input
+	crlf   bool        // write newlines as CR LF
+
+	// Host commands; see process.go.
+	allow []string // commands that may be run
+	proc  *process // the running command, if any
 }
 
-func (c *Console) Vector() uint16 { return c.mem.short(0x0) }
+// setRaw configures the console for a terminal in raw mode, so that
+// arrow keys are delivered to the given controller and newlines are
+// written as CR LF.
+func (c *Console) setRaw(arrows *Controller) {
+	c.arrows = arrows
+	c.crlf = true
+}
 
-func (c *Console) In(p byte) byte {
-	switch p {
-	case 0x2:
-		select {
-		case b := <-c.input:
-			c.mem[p] = b
-			return b
-		default:
+// Console input types, as reported on the type port.
+const (
+	consoleStd byte = 0x1 // a byte of standard input
+	consoleArg byte = 0x2 // a byte of a command-line argument
+	consoleEOA byte = 0x3 // the end of an argument, if more follow
+	consoleEnd byte = 0x4 // the end of the last argument, or of standard input
+)
+
+// consoleInput is a byte of input and its type.
+type consoleInput struct {
+	b, typ byte
+	proc   *process // the command that sent the input, if any
+}
+
+// setArgs queues the given command-line arguments for delivery once the
+// reset vector has run. Each byte of an argument is delivered with type
+// consoleArg, followed by a newline with type consoleEOA, or consoleEnd
+// for the last argument. While the reset vector runs, the type port
+// holds the number of arguments.
+func (c *Console) setArgs(args []string) {
+	c.args = nil
+	for i, a := range args {
+		for _, b := range []byte(a) {
+			c.args = append(c.args, consoleInput{b, consoleArg, nil})
 		}
+		typ := consoleEOA
+		if i == len(args)-1 {
+			typ = consoleEnd
+		}
+		c.args = append(c.args, consoleInput{'\n', typ, nil})
+	}
+	n := len(args)
+	if n > 0xff {
+		n = 0xff
+	}
+	c.mem[0x7] = byte(n)
+}
+
+// nextArg delivers the next byte of the command-line arguments
+// and returns the console vector. Unlike other input, arguments
+// are not recorded.
+func (c *Console) nextArg() uint16 {
+	in := c.args[0]
+	c.args = c.args[1:]
+	return c.write(in)
+}
+
+// readTyped makes the console read text typed into the UI
+// instead of its input reader.
+func (c *Console) readTyped() {
+	c.typed = newTextBuffer()
+	c.in = c.typed
+}
+
+// typeText delivers text typed into the UI to the console,
+// if it is reading typed text.
+func (c *Console) typeText(p []byte) {
+	if c.typed != nil {
+		c.typed.Write(p)
+	}
+}
+
+func (c *Console) Vector() uint16 { return c.mem.short(0x0) }
+
+func (c *Console) In(p byte) byte { return c.mem[p] }
+
+// next delivers the input that is ready to the device
+// and returns the console vector.
+func (c *Console) next() uint16 {
+	in := <-c.input
+	if in.proc != nil && in.proc != c.proc {
+		// Input from a command that has since been killed.
+		return 0
+	}
+	switch in.typ {
+	case consoleEnd:
+		c.ended = true
+	case consoleExit:
+		c.proc = nil
+	}
+	return c.deliver(in)
+}
+
+// listening reports whether the console may yet receive input,
+// because its vector has been set and its input has not ended,
+// or because a host command is running.
+func (c *Console) listening() bool {
+	return c.reading && !c.ended || c.proc != nil
+}
+
+// listen makes the channels on which input is sent to the console.
+func (c *Console) listen() {
+	if c.input != nil {
+		return
 	}
-	return c.mem[p]
+	var (
+		input = make(chan consoleInput, 1)
+		ready = make(chan bool)
+	)
+	c.input, c.Ready = input, ready
+	c.send, c.ready = input, ready
+}
+
+// deliver writes the given input to the device
+// and returns the console vector.
+func (c *Console) deliver(in consoleInput) uint16 {
+	if c.record != nil {
+		c.record(in)
+	}
+	if in.typ == consoleExit {
+		c.mem[0x5] = 0xff
+		c.mem[0x6] = in.b
+	}
+	return c.write(in)
+}
+
+// write writes the given input to the device memory
+// and returns the console vector.
+func (c *Console) write(in consoleInput) uint16 {
+	c.mem[0x2] = in.b
+	c.mem[0x7] = in.typ
+	return c.Vector()
+}
+
+// output writes b to w, translating newlines if necessary.
+func (c *Console) output(w io.Writer, b byte) {
+	if b == '\n' && c.crlf {
+		w.Write([]byte{'\r', '\n'})
+		return
+	}
+	w.Write([]byte{b})
 }
 
 func (c *Console) Out(p, b byte) {
 	c.mem[p] = b
 	switch p {
 	case 0x01:
-		if c.input == nil {
-			var (
-				input = make(chan byte, 1)
-				ready = make(chan bool)
-			)
-			go c.readInput(input, ready)
-			c.input, c.Ready = input, ready
+		if !c.reading && c.in != nil {
+			c.reading = true
+			c.listen()
+			go c.readInput(c.send, c.ready)
 		}
 	case 0x08:
-		c.out.Write([]byte{b})
+		if p := c.proc; p != nil && p.stdin != nil {
+			p.stdin.Write([]byte{b})
+			return
+		}
+		c.output(c.out, b)
 	case 0x09:
-		c.err.Write([]byte{b})
+		c.output(c.err, b)
+	case 0xf:
+		c.exec()
 	}
 }
 
-func (c *Console) readInput(input chan<- byte, ready chan<- bool) {
+// readInput sends each byte read from c.in to input, followed by a
+// zero byte of type consoleEnd at the end of the input or on error.
+// If c.arrows is set, arrow key escape sequences are instead delivered
+// to it as button presses.
+func (c *Console) readInput(input chan<- consoleInput, ready chan<- bool) {
+	buf := make([]byte, 256)
 	for {
-		var b [1]byte
-		if _, err := c.in.Read(b[:]); err != nil {
-			log.Printf("reading stdin: %v", err)
+		n, err := c.in.Read(buf)
+		// Terminals write each escape sequence at once, so
+		// assume that a read returns whole sequences.
+		for p := buf[:n]; len(p) > 0; {
+			if c.arrows != nil {
+				if mask, size := arrowKey(p); size > 0 {
+					c.pressButton(mask)
+					p = p[size:]
+					continue
+				}
+			}
+			if !c.put(consoleInput{p[0], consoleStd, nil}, input, ready) {
+				// Leave the rest of the input for the next machine.
+				if r, ok := c.in.(*inputReader); ok {
+					r.unread(p)
+				}
+				return
+			}
+			p = p[1:]
+		}
+		if err != nil {
+			if c.closed() {
+				return
+			}
+			if err != io.EOF {
+				log.Printf("reading stdin: %v", err)
+			}
+			c.put(consoleInput{0, consoleEnd, nil}, input, ready)
 			return
 		}
-		input <- b[0]
-		ready <- true
 	}
 }
+
+// put sends in to the console's input, and reports whether it was sent
+// before the console was closed.
+func (c *Console) put(in consoleInput, input chan<- consoleInput, ready chan<- bool) bool {
+	if c.closed() {
+		return false
+	}
+	select {
+	case input <- in:
+	case <-c.done:
+		return false
+	}
+	select {
+	case ready <- true:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *Console) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the console's input and any host command it is running.
+func (c *Console) close() {
+	if c.closed() {
+		return
+	}
+	close(c.done)
+	c.kill()
+	if c.typed != nil {
+		c.typed.close()
+	}
+}
+
+// arrowKey reports whether p begins with an escape sequence for an arrow
+// key, and if so returns the mask of its button and the sequence's size.
+func arrowKey(p []byte) (mask byte, size int) {
+	if len(p) < 3 || p[0] != 0x1b || p[1] != '[' && p[1] != 'O' {
+		return 0, 0
+	}
+	switch p[2] {
+	case 'A':
+		return buttonUp, 3
+	case 'B':
+		return buttonDown, 3
+	case 'C':
+		return buttonRight, 3
+	case 'D':
+		return buttonLeft, 3
+	}
+	return 0, 0
+}
+
+// pressButton queues a press and release of the given button
+// of player 1's controller.
+func (c *Console) pressButton(mask byte) {
+	var s ControllerState
+	*s.button(mask) = true
+	c.arrows.Set(&s)
+	*s.button(mask) = false
+	c.arrows.Set(&s)
+}
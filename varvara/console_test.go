@@ -0,0 +1,215 @@
+package varvara
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nf/nux/uxn"
+)
+
+// printPort writes the value of the given device port to the console.
+func printPort(port byte) []byte {
+	return []byte{byte(uxn.LIT), port, byte(uxn.DEI), byte(uxn.LIT), 0x18, byte(uxn.DEO)}
+}
+
+func TestConsoleArgs(t *testing.T) {
+	// The reset vector prints the type port, which holds the number of
+	// arguments, and the console vector prints each byte and its type.
+	handler := cat(printPort(0x12), printPort(0x17), []byte{byte(uxn.BRK)})
+	rom := assemble(handler, printPort(0x17), deo2(0x10, spriteAddr))
+
+	var out bytes.Buffer
+	r := NewRunner(NoDisplay, false, nil)
+	r.SetOutput(&out)
+	r.SetArgs([]string{"ab", "c"})
+	r.SetReplayInput(&InputRecording{})
+	r.Run(rom)
+	want := "\x02" + "a\x02b\x02\n\x03" + "c\x02\n\x04"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestConsoleStdinEnd(t *testing.T) {
+	v := newTestVarvara(t)
+	v.con.in = strings.NewReader("hi")
+	v.con.Out(0x1, 0x01) // vector
+
+	var got []consoleInput
+	for len(got) < 3 {
+		<-v.con.Ready
+		v.con.next()
+		got = append(got, consoleInput{v.con.In(0x2), v.con.In(0x7), nil})
+	}
+	want := []consoleInput{{'h', consoleStd, nil}, {'i', consoleStd, nil}, {0, consoleEnd, nil}}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("input %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestConsoleHeadlessExit(t *testing.T) {
+	// A filter that echoes its input and the type of each byte.
+	handler := cat(printPort(0x12), printPort(0x17), []byte{byte(uxn.BRK)})
+	for _, c := range []struct {
+		name string
+		rom  []byte
+		want string
+	}{
+		{"filter", assemble(handler, deo2(0x10, spriteAddr)), "a\x01b\x01\x00\x04"},
+		{"no vectors", assemble(nil, deo(0x18, 'x')), "x"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			r := NewRunner(NoDisplay, false, nil)
+			r.SetInput(strings.NewReader("ab"))
+			r.SetOutput(&out)
+			done := make(chan bool)
+			go func() {
+				r.Run(c.rom)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run did not return")
+			}
+			if got := out.String(); got != c.want {
+				t.Errorf("output = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestConsoleRaw(t *testing.T) {
+	var out bytes.Buffer
+	v := newTestVarvara(t)
+	v.con.out = &out
+	v.con.in = strings.NewReader("a\x1b[A\x1bOD\x1b")
+	v.con.setRaw(&v.cntrl)
+	v.con.Out(0x1, 0x01) // vector
+
+	var got []byte
+	for {
+		<-v.con.Ready
+		v.con.next()
+		if v.con.In(0x7) == consoleEnd {
+			break
+		}
+		got = append(got, v.con.In(0x2))
+	}
+	if want := "a\x1b"; string(got) != want {
+		t.Errorf("console input = %q, want %q", got, want)
+	}
+
+	var buttons []byte
+	for len(v.cntrl.Ready) > 0 {
+		<-v.cntrl.Ready
+		v.cntrl.next()
+		buttons = append(buttons, v.cntrl.In(0x2))
+	}
+	if want := []byte{buttonUp, 0, buttonLeft, 0}; !bytes.Equal(buttons, want) {
+		t.Errorf("buttons = %x, want %x", buttons, want)
+	}
+
+	v.con.Out(0x8, 'x')
+	v.con.Out(0x8, '\n')
+	if got, want := out.String(), "x\r\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestConsoleExec(t *testing.T) {
+	for _, name := range []string{"echo", "cat", "false"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not found", name)
+		}
+	}
+	v := newTestVarvara(t)
+	v.con.in = nil
+	v.con.Out(0x0, 0x01) // vector
+	v.con.Out(0x1, 0x00)
+	run := func(cmd string, mode byte) {
+		copy(v.m.Mem[spriteAddr:], cmd+"\x00")
+		v.con.Out(0xc, spriteAddr>>8)
+		v.con.Out(0xd, spriteAddr&0xff)
+		v.con.Out(0xe, mode)
+		v.con.Out(0xf, 0x01)
+	}
+	// read delivers console input until the command exits,
+	// and returns its output and exit status.
+	read := func() (string, byte) {
+		var got []byte
+		for {
+			<-v.con.Ready
+			if v.con.next() == 0 {
+				t.Fatal("console vector not called")
+			}
+			if v.con.In(0x7) == consoleExit {
+				return string(got), v.con.In(0x2)
+			}
+			got = append(got, v.con.In(0x2))
+		}
+	}
+
+	run("echo hi", procRead)
+	if v.con.proc != nil || v.con.In(0x5) != 0x00 {
+		t.Fatalf("ran a command that is not allowed")
+	}
+
+	v.con.allow = []string{"echo", "cat", "false"}
+	run("echo hi", procRead)
+	if got := v.con.In(0x5); got != 0x01 {
+		t.Fatalf("live = %#x, want 0x01", got)
+	}
+	if got, code := read(); got != "hi\n" || code != 0 {
+		t.Errorf("echo output = %q, exit %d; want %q, exit 0", got, code, "hi\n")
+	}
+	if live, exit := v.con.In(0x5), v.con.In(0x6); live != 0xff || exit != 0 {
+		t.Errorf("after exit, live = %#x, exit = %#x, want 0xff, 0", live, exit)
+	}
+	if v.con.proc != nil {
+		t.Error("command still running after its exit was delivered")
+	}
+
+	run("false", 0)
+	if _, code := read(); code != 1 {
+		t.Errorf("false exit = %d, want 1", code)
+	}
+	if exit := v.con.In(0x6); exit != 1 {
+		t.Errorf("after false, exit = %#x, want 0x01", exit)
+	}
+
+	run("cat", procRead|procWrite)
+	v.con.Out(0x8, 'x')
+	run("", procClose)
+	if got, _ := read(); got != "x" {
+		t.Errorf("cat output = %q, want %q", got, "x")
+	}
+
+	// Output of a killed command that has not been delivered,
+	// and its exit, are dropped.
+	run("echo killed", procRead)
+	<-v.con.Ready
+	run("", procKill)
+	if v.con.next() != 0 {
+		t.Error("output of a killed command was delivered")
+	}
+	if live := v.con.In(0x5); live != 0x00 {
+		t.Errorf("after kill, live = %#x, want 0x00", live)
+	}
+	run("echo hi", procRead)
+	for {
+		<-v.con.Ready
+		if v.con.next() != 0 {
+			break
+		}
+	}
+	if got := v.con.In(0x2); got != 'h' {
+		t.Errorf("after kill, first input = %q, want %q", got, 'h')
+	}
+}
@@ -1,49 +1,107 @@
 package varvara
 
 type Controller struct {
-	inputDevice
+	inputDevice[ControllerState]
 }
 
 type ControllerState struct {
-	A, B, Select, Start   bool
-	Up, Down, Left, Right bool
+	Buttons            // Player 1.
+	Player  [3]Buttons // Players 2, 3, and 4.
 
+	// Key is the ASCII value of a key press, or zero.
+	// The device reports each key press once.
 	Key byte
 }
 
-func (c *Controller) Set(s *ControllerState) {
-	u := false
+// Buttons holds the state of one player's controller buttons.
+type Buttons struct {
+	A, B, Select, Start   bool
+	Up, Down, Left, Right bool
+}
 
-	var b byte
-	if s.A {
-		b |= 0x01
+// player returns the buttons of the given player, from 0 to 3.
+func (s *ControllerState) player(p int) *Buttons {
+	if p == 0 {
+		return &s.Buttons
 	}
-	if s.B {
-		b |= 0x02
-	}
-	if s.Select {
-		b |= 0x04
-	}
-	if s.Start {
-		b |= 0x08
-	}
-	if s.Up {
-		b |= 0x10
+	return &s.Player[p-1]
+}
+
+// button returns the field of b for the given button mask,
+// which must have exactly one bit set.
+func (b *Buttons) button(mask byte) *bool {
+	switch mask {
+	case buttonA:
+		return &b.A
+	case buttonB:
+		return &b.B
+	case buttonSelect:
+		return &b.Select
+	case buttonStart:
+		return &b.Start
+	case buttonUp:
+		return &b.Up
+	case buttonDown:
+		return &b.Down
+	case buttonLeft:
+		return &b.Left
+	case buttonRight:
+		return &b.Right
+	default:
+		panic("invalid button mask")
 	}
-	if s.Down {
-		b |= 0x20
+}
+
+// byte returns the buttons as reported by the device.
+func (b *Buttons) byte() byte {
+	var v byte
+	for mask := buttonA; mask != 0; mask <<= 1 {
+		if *b.button(mask) {
+			v |= mask
+		}
 	}
-	if s.Left {
-		b |= 0x40
+	return v
+}
+
+// set sets the buttons from their value as reported by the device.
+func (b *Buttons) set(v byte) {
+	for mask := buttonA; mask != 0; mask <<= 1 {
+		*b.button(mask) = v&mask != 0
 	}
-	if s.Right {
-		b |= 0x80
+}
+
+// Set queues an event that changes the controller state to s.
+func (c *Controller) Set(s *ControllerState) { c.push(*s) }
+
+// next delivers the next queued event to the device and returns the
+// controller vector if the event changed the controller's state.
+func (c *Controller) next() uint16 {
+	s, ok := c.pop()
+	if !ok || !c.apply(&s) {
+		return 0
 	}
-	u = c.mem.setChanged(0x2, b) || u
+	return c.Vector()
+}
+
+// apply writes s to the device memory
+// and reports whether it changed the device state.
+func (c *Controller) apply(s *ControllerState) bool {
+	u := false
 
-	u = c.mem.setChanged(0x3, s.Key) || u
+	u = c.mem.setChanged(0x2, s.Buttons.byte()) || u
+	for i := range s.Player {
+		u = c.mem.setChanged(0x5+byte(i), s.Player[i].byte()) || u
+	}
 
-	if u {
-		c.updated()
+	if s.Key != 0 {
+		// Every key press is a change, even if the key is the same
+		// as the last and clearKey has not been called since.
+		c.mem[0x3] = s.Key
+		u = true
 	}
+
+	return u
 }
+
+// clearKey resets the key port once a key press has been handled.
+func (c *Controller) clearKey() { c.mem[0x3] = 0 }
@@ -1,11 +1,11 @@
 package varvara
 
-import "time"
-
-type Datetime struct{}
+type Datetime struct {
+	src *timeSource
+}
 
-func (Datetime) In(p byte) byte {
-	t := time.Now()
+func (d *Datetime) In(p byte) byte {
+	t := d.src.now()
 	switch p {
 	case 0x0:
 		return byte(t.Year() >> 8)
@@ -38,4 +38,4 @@ func (Datetime) In(p byte) byte {
 	}
 }
 
-func (Datetime) Out(p, b byte) {}
+func (d *Datetime) Out(p, b byte) {}
@@ -0,0 +1,18 @@
+//go:build darwin && cgo
+
+package varvara
+
+/*
+#cgo LDFLAGS: -framework CoreGraphics
+#include <CoreGraphics/CoreGraphics.h>
+*/
+import "C"
+
+import "image"
+
+// displaySize returns the size in points of the main display,
+// the unit in which windows are sized on macOS.
+func displaySize() (image.Point, error) {
+	b := C.CGDisplayBounds(C.CGMainDisplayID())
+	return image.Point{int(b.size.width), int(b.size.height)}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !((linux && !android) || dragonfly || openbsd) && !windows && !(darwin && cgo)
+
+package varvara
+
+import (
+	"errors"
+	"image"
+)
+
+// displaySize reports that the size of the display is unknown,
+// as it is on platforms without a windowing system that nux supports.
+func displaySize() (image.Point, error) {
+	return image.Point{}, errors.New("cannot get the size of the display on this platform")
+}
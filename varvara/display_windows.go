@@ -0,0 +1,20 @@
+package varvara
+
+import (
+	"errors"
+	"image"
+	"syscall"
+)
+
+var procGetSystemMetrics = syscall.NewLazyDLL("user32.dll").NewProc("GetSystemMetrics")
+
+// displaySize returns the size in pixels of the primary monitor.
+func displaySize() (image.Point, error) {
+	const smCXScreen, smCYScreen = 0, 1
+	w, _, _ := procGetSystemMetrics.Call(smCXScreen)
+	h, _, _ := procGetSystemMetrics.Call(smCYScreen)
+	if w == 0 || h == 0 {
+		return image.Point{}, errors.New("cannot get the size of the display")
+	}
+	return image.Point{int(w), int(h)}, nil
+}
@@ -0,0 +1,30 @@
+//go:build (linux && !android) || dragonfly || openbsd
+
+package varvara
+
+import (
+	"image"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xinerama"
+	"github.com/jezek/xgb/xproto"
+)
+
+// displaySize returns the size in pixels of the first monitor of the
+// X display, or of the whole X screen if it cannot tell the monitors apart.
+func displaySize() (image.Point, error) {
+	c, err := xgb.NewConn()
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer c.Close()
+	if xinerama.Init(c) == nil {
+		r, err := xinerama.QueryScreens(c).Reply()
+		if err == nil && len(r.ScreenInfo) > 0 {
+			s := r.ScreenInfo[0]
+			return image.Point{int(s.Width), int(s.Height)}, nil
+		}
+	}
+	s := xproto.Setup(c).DefaultScreen(c)
+	return image.Point{int(s.WidthInPixels), int(s.HeightInPixels)}, nil
+}
@@ -2,30 +2,44 @@ package varvara
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path"
-	"path/filepath"
-	"strings"
 )
 
 type File struct {
 	mem  deviceMem
 	main []byte // view of main memory
+	fs   FS
 
 	append bool
 	name   string
 
 	reader io.ReadCloser
 	writer io.WriteCloser
+
+	misuse func(p byte, msg string) // reports misuse of port p
 }
 
 func (f *File) setSuccess(v int) { f.mem.setShort(0x2, uint16(v)) }
 func (f *File) length() uint16   { return f.mem.short(0xa) }
 
+// buffer returns the region of main memory at the address held by the
+// given port and of the length held by the length port, or false if it
+// extends past the end of memory.
+func (f *File) buffer(p, addrPort byte) ([]byte, bool) {
+	addr, n := int(f.mem.short(addrPort)), int(f.length())
+	if addr+n > 0x10000 {
+		f.misuse(p, fmt.Sprintf("buffer at %.4x of length %.4x extends past end of memory", addr, n))
+		return nil, false
+	}
+	return f.main[addr : addr+n], true
+}
+
 func (f *File) In(p byte) byte {
 	switch p {
 	case 0x8, 0x9: // name
@@ -40,73 +54,76 @@ func (f *File) Out(p, b byte) {
 
 	case 0x5: // stat
 		f.setSuccess(0)
-		if f.length() == 0 {
-			panic("file stat before setting length (or set zero length)")
-		}
 		if f.name == "" {
-			panic("file stat before setting name")
-		}
-		fi, err := os.Stat(filepath.FromSlash(f.name))
-		if err != nil {
-			log.Printf("stat file: %v", err)
+			f.misuse(p, "stat before setting name")
 			return
 		}
-		info := fileInfoBytes(fi)
-		if len(info) > int(f.length()) {
+		buf, ok := f.buffer(p, 0x4)
+		if !ok {
 			return
 		}
-		addr := f.mem.short(0x4)
-		n := copy(f.main[addr:addr+f.length()], info)
-		f.setSuccess(n)
+		f.stat(buf)
+		f.setSuccess(len(buf))
 
 	case 0x6: // delete
+		f.setSuccess(0)
 		if f.name == "" {
-			panic("file delete before setting name")
+			f.misuse(p, "delete before setting name")
+			return
 		}
-		if err := os.Remove(filepath.FromSlash(f.name)); err != nil {
+		if err := f.fs.Remove(f.name); err != nil {
 			log.Printf("delete file: %v", err)
+			return
 		}
+		f.setSuccess(1)
 
-	case 0x7:
-		f.append = p == 0x01
+	case 0x7: // append
+		f.append = b == 0x01
 
 	case 0x9: // name
 		f.close()
 		addr := f.mem.short(0x8)
 		name, _, ok := bytes.Cut(f.main[addr:], []byte{0})
 		if !ok {
-			panic("unterminated file name")
+			f.name = ""
+			f.misuse(p, "unterminated name")
+			return
 		}
 		n := string(name)
 		if n != "" {
+			// Names outside the file system, such as absolute names
+			// or those beginning with "..", are rejected by f.fs.
 			n = path.Clean(n)
-			if path.IsAbs(n) || strings.HasPrefix(n, "../") {
-				panic(fmt.Errorf("bad file name %q", n))
-			}
 		}
 		f.name = n
 
 	case 0xd: // read
 		f.setSuccess(0)
 		if f.length() == 0 {
-			panic("file read before setting length (or set zero length)")
+			f.misuse(p, "read before setting length (or with zero length)")
+			return
 		}
 		if f.writer != nil {
-			panic("file read after write; should re-open")
+			// Reopen the file for reading.
+			f.close()
 		}
 		if f.reader == nil {
 			if f.name == "" {
-				panic("file read before setting name")
+				f.misuse(p, "read before setting name")
+				return
 			}
-			r, err := fileReader(filepath.FromSlash(f.name))
+			r, err := fileReader(f.fs, f.name)
 			if err != nil {
 				log.Printf("opening file: %v", err)
 				return
 			}
 			f.reader = r
 		}
-		addr := f.mem.short(0xc)
-		n, err := f.reader.Read(f.main[addr : addr+f.length()])
+		buf, ok := f.buffer(p, 0xc)
+		if !ok {
+			return
+		}
+		n, err := f.reader.Read(buf)
 		if err != nil && err != io.EOF {
 			log.Printf("reading file: %v", err)
 			return
@@ -116,28 +133,34 @@ func (f *File) Out(p, b byte) {
 	case 0xf: // write
 		f.setSuccess(0)
 		if f.length() == 0 {
-			panic("file write before setting length (or set zero length)")
+			f.misuse(p, "write before setting length (or with zero length)")
+			return
 		}
 		if f.reader != nil {
-			panic("file write after read; should re-open")
+			// Reopen the file for writing.
+			f.close()
 		}
 		if f.writer == nil {
 			if f.name == "" {
-				panic("file write before setting name")
+				f.misuse(p, "write before setting name")
+				return
 			}
-			flag := os.O_WRONLY | os.O_CREATE
+			flag := os.O_CREATE | os.O_TRUNC
 			if f.append {
-				flag |= os.O_APPEND
+				flag = os.O_CREATE | os.O_APPEND
 			}
-			fp, err := os.OpenFile(filepath.FromSlash(f.name), flag, 0644)
+			fp, err := f.fs.OpenFile(f.name, flag)
 			if err != nil {
 				log.Printf("opening file: %v", err)
 				return
 			}
 			f.writer = fp
 		}
-		addr := f.mem.short(0xe)
-		n, err := f.writer.Write(f.main[addr : addr+f.length()])
+		buf, ok := f.buffer(p, 0xe)
+		if !ok {
+			return
+		}
+		n, err := f.writer.Write(buf)
 		if err != nil {
 			log.Printf("writing file: %v", err)
 			return
@@ -161,48 +184,94 @@ func (f *File) close() {
 	}
 }
 
-func fileReader(name string) (io.ReadCloser, error) {
-	fi, err := os.Stat(name)
+// stat fills p with a description of the named file: its size as
+// hexadecimal digits, or '?' if the size has too many digits, '-' if it
+// is a directory, or '!' if it does not exist or cannot be read.
+func (f *File) stat(p []byte) {
+	fill := func(c byte) {
+		for i := range p {
+			p[i] = c
+		}
+	}
+	fi, err := fs.Stat(f.fs, f.name)
+	switch {
+	case err != nil:
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("stat file: %v", err)
+		}
+		fill('!')
+	case fi.IsDir():
+		fill('-')
+	default:
+		size := uint64(fi.Size())
+		for i := len(p) - 1; i >= 0; i-- {
+			p[i] = hexDigits[size&0xf]
+			size >>= 4
+		}
+		if size > 0 {
+			fill('?')
+		}
+	}
+}
+
+const hexDigits = "0123456789abcdef"
+
+func fileReader(fsys FS, name string) (io.ReadCloser, error) {
+	fi, err := fs.Stat(fsys, name)
 	if err != nil {
 		return nil, err
 	}
 
 	if !fi.IsDir() {
-		return os.Open(name)
+		return fsys.Open(name)
 	}
 
-	des, err := os.ReadDir(name)
+	des, err := fs.ReadDir(fsys, name)
 	if err != nil {
 		return nil, err
 	}
-	var fis []fs.FileInfo
+	d := &dirReader{}
 	for _, de := range des {
 		fi, err := de.Info()
-		if err != nil {
-			return nil, err
-		}
-		fis = append(fis, fi)
+		d.entries = append(d.entries, dirEntry(de.Name(), fi, err))
 	}
-	r, w := io.Pipe()
-	go func() {
-		defer w.Close()
-		for _, fi := range fis {
-			w.Write(fileInfoBytes(fi))
-		}
-	}()
-	return io.NopCloser(r), nil
+	return d, nil
+}
+
+// dirReader reads the entries of a directory listing. Each read returns
+// as many whole entries as fit, so that entries are never split across
+// reads.
+type dirReader struct {
+	entries [][]byte // yet to be read
 }
 
-func fileInfoBytes(fi fs.FileInfo) []byte {
-	var buf bytes.Buffer
-	if fi.IsDir() {
-		buf.WriteString("---- ")
-	} else if size := fi.Size(); size > 0xffff {
-		buf.WriteString("???? ")
-	} else {
-		fmt.Fprintf(&buf, "%.4x ", size)
+func (d *dirReader) Read(p []byte) (int, error) {
+	if len(d.entries) == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for len(d.entries) > 0 && len(d.entries[0]) <= len(p)-n {
+		n += copy(p[n:], d.entries[0])
+		d.entries = d.entries[1:]
+	}
+	return n, nil
+}
+
+func (d *dirReader) Close() error { return nil }
+
+// dirEntry returns the line describing a file in a directory listing:
+// its size as four hexadecimal digits, or "????" if the size has more
+// digits, "----" if it is a directory, or "!!!!" if it cannot be read,
+// followed by its name, with a slash if it is a directory.
+func dirEntry(name string, fi fs.FileInfo, err error) []byte {
+	switch {
+	case err != nil:
+		return []byte(fmt.Sprintf("!!!! %s\n", name))
+	case fi.IsDir():
+		return []byte(fmt.Sprintf("---- %s/\n", name))
+	case fi.Size() > 0xffff:
+		return []byte(fmt.Sprintf("???? %s\n", name))
+	default:
+		return []byte(fmt.Sprintf("%04x %s\n", fi.Size(), name))
 	}
-	buf.WriteString(filepath.ToSlash(fi.Name()))
-	buf.WriteByte('\n')
-	return buf.Bytes()
 }
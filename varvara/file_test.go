@@ -0,0 +1,278 @@
+package varvara
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nf/nux/uxn"
+)
+
+// testFile is a File device operating on a temporary directory,
+// driven through its ports as a program would.
+type testFile struct {
+	t   *testing.T
+	dir string
+	v   *Varvara
+	f   *File
+}
+
+const (
+	testNameAddr = 0x1000
+	testDataAddr = 0x2000
+)
+
+func newTestFile(t *testing.T) *testFile {
+	v := newTestVarvara(t)
+	dir := t.TempDir()
+	v.setFS(DirFS(dir))
+	return &testFile{t: t, dir: dir, v: v, f: &v.fileA}
+}
+
+func (f *testFile) setShort(port byte, v uint16) {
+	f.f.Out(port, byte(v>>8))
+	f.f.Out(port+1, byte(v))
+}
+
+func (f *testFile) success() int { return int(f.f.mem.short(0x2)) }
+
+func (f *testFile) name(name string) {
+	copy(f.f.main[testNameAddr:], name+"\x00")
+	f.setShort(0x8, testNameAddr)
+}
+
+func (f *testFile) write(data string) int {
+	copy(f.f.main[testDataAddr:], data)
+	f.setShort(0xa, uint16(len(data)))
+	f.setShort(0xe, testDataAddr)
+	return f.success()
+}
+
+func (f *testFile) read(n int) string {
+	f.setShort(0xa, uint16(n))
+	f.setShort(0xc, testDataAddr)
+	return string(f.f.main[testDataAddr : testDataAddr+f.success()])
+}
+
+func (f *testFile) stat(n int) string {
+	f.setShort(0xa, uint16(n))
+	f.setShort(0x4, testDataAddr)
+	if f.success() != n {
+		f.t.Errorf("stat success = %d, want %d", f.success(), n)
+	}
+	return string(f.f.main[testDataAddr : testDataAddr+n])
+}
+
+func (f *testFile) writeFile(name, data string) {
+	f.t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(data), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *testFile) readFile(name string) string {
+	f.t.Helper()
+	b, err := os.ReadFile(filepath.Join(f.dir, name))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFileWrite(t *testing.T) {
+	f := newTestFile(t)
+	f.name("a.txt")
+	if n := f.write("hello"); n != 5 {
+		t.Errorf("write success = %d, want 5", n)
+	}
+	f.write(", world")
+	f.name("a.txt")
+	if got, want := f.readFile("a.txt"), "hello, world"; got != want {
+		t.Errorf("after writes, file = %q, want %q", got, want)
+	}
+
+	// Writing without append replaces the file.
+	f.write("abc")
+	f.name("a.txt")
+	if got, want := f.readFile("a.txt"), "abc"; got != want {
+		t.Errorf("after rewrite, file = %q, want %q", got, want)
+	}
+
+	f.f.Out(0x7, 0x01)
+	f.write("def")
+	f.name("a.txt")
+	if got, want := f.readFile("a.txt"), "abcdef"; got != want {
+		t.Errorf("after append, file = %q, want %q", got, want)
+	}
+
+	f.f.Out(0x7, 0x00)
+	f.write("x")
+	f.name("")
+	if got, want := f.readFile("a.txt"), "x"; got != want {
+		t.Errorf("after append off, file = %q, want %q", got, want)
+	}
+}
+
+func TestFileDelete(t *testing.T) {
+	f := newTestFile(t)
+	f.writeFile("a.txt", "a")
+	f.name("a.txt")
+	f.f.Out(0x6, 0x01)
+	if got := f.success(); got != 1 {
+		t.Errorf("delete success = %d, want 1", got)
+	}
+	if _, err := os.Stat(filepath.Join(f.dir, "a.txt")); err == nil {
+		t.Error("file still exists after delete")
+	}
+	f.f.Out(0x6, 0x01)
+	if got := f.success(); got != 0 {
+		t.Errorf("delete of missing file success = %d, want 0", got)
+	}
+}
+
+func TestFileStat(t *testing.T) {
+	f := newTestFile(t)
+	f.writeFile("small", strings.Repeat("x", 0x12))
+	f.writeFile("large", strings.Repeat("x", 0x12345))
+	if err := os.Mkdir(filepath.Join(f.dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"small", 4, "0012"},
+		{"small", 2, "12"},
+		{"small", 1, "?"},
+		{"large", 4, "????"},
+		{"large", 5, "12345"},
+		{"large", 8, "00012345"},
+		{"dir", 4, "----"},
+		{"missing", 4, "!!!!"},
+		{"../small", 4, "!!!!"},
+	} {
+		f.name(c.name)
+		if got := f.stat(c.n); got != c.want {
+			t.Errorf("stat %s with length %d = %q, want %q", c.name, c.n, got, c.want)
+		}
+	}
+}
+
+func TestFileReadDir(t *testing.T) {
+	f := newTestFile(t)
+	f.writeFile("a", "abc")
+	f.writeFile("big", strings.Repeat("x", 0x10000))
+	if err := os.Mkdir(filepath.Join(f.dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0003 a\n", "???? big\n", "---- sub/\n"}
+
+	// Reading in small pieces returns one whole entry at a time.
+	for i := 0; i < 2; i++ {
+		f.name(".")
+		var got []string
+		for {
+			s := f.read(12)
+			if s == "" {
+				break
+			}
+			got = append(got, s)
+		}
+		if !equalStrings(got, want) {
+			t.Errorf("reading directory (pass %d) = %q, want %q", i, got, want)
+		}
+	}
+
+	f.name(".")
+	if got := f.read(0x100); got != strings.Join(want, "") {
+		t.Errorf("reading directory at once = %q, want %q", got, strings.Join(want, ""))
+	}
+	if got := f.read(0x100); got != "" {
+		t.Errorf("reading past end of directory = %q, want nothing", got)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	f := newTestFile(t)
+	f.name("a.txt")
+	f.write("hello")
+	if got, want := f.read(0x100), "hello"; got != want {
+		t.Errorf("read after write = %q, want %q", got, want)
+	}
+	f.write("bye")
+	if got, want := f.read(0x100), "bye"; got != want {
+		t.Errorf("read after write after read = %q, want %q", got, want)
+	}
+	f.name("")
+	if got, want := f.readFile("a.txt"), "bye"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestFileMisuse(t *testing.T) {
+	f := newTestFile(t)
+	var got []Misuse
+	f.v.onMisuse = func(m Misuse) { got = append(got, m) }
+
+	if s := f.read(0x10); s != "" || f.success() != 0 {
+		t.Errorf("read before name = %q, success %d; want nothing", s, f.success())
+	}
+	f.name("a.txt")
+	f.setShort(0xa, 0)
+	f.setShort(0xe, testDataAddr)
+	f.setShort(0xa, 0x10)
+	f.setShort(0xe, 0xfff8)
+	f.f.Out(0x6, 0x01) // deleting a missing file fails, but is not misuse
+
+	want := []Misuse{
+		{Device: "file", Port: 0xad, Addr: 0xff, Msg: "read before setting name"},
+		{Device: "file", Port: 0xaf, Addr: 0xff, Msg: "write before setting length (or with zero length)"},
+		{Device: "file", Port: 0xaf, Addr: 0xff, Msg: "buffer at fff8 of length 0010 extends past end of memory"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got misuses %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("misuse %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFileMisuseHalt(t *testing.T) {
+	// The halt vector prints the halt code and exits.
+	handler := []byte{
+		byte(uxn.LIT), 0x18, byte(uxn.DEO),
+		byte(uxn.POP), byte(uxn.POP2),
+		byte(uxn.LIT2), 0x81, 0x0f, byte(uxn.DEO),
+		byte(uxn.BRK),
+	}
+	rom := assemble(handler,
+		deo2(0x00, spriteAddr),
+		deo2(0xaa, 1),
+		deo2(0xac, 0x2000), // read before setting name
+		deo(0x18, 'x'),
+	)
+	var (
+		out    bytes.Buffer
+		misuse []Misuse
+		r      = NewRunner(NoDisplay, false, nil)
+	)
+	r.SetOutput(&out)
+	r.SetMisuseFunc(func(m Misuse) { misuse = append(misuse, m) })
+	r.SetHaltOnMisuse(true)
+	r.SetReplayInput(&InputRecording{})
+	code, _ := r.Run(rom)
+	if got, want := out.String(), string([]byte{byte(MisuseHalt)}); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if len(misuse) != 1 {
+		t.Errorf("got misuses %v, want one", misuse)
+	}
+}
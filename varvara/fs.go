@@ -0,0 +1,479 @@
+package varvara
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is a file system on which the File device operates.
+// Names are slash-separated paths, as for fs.FS.
+type FS interface {
+	fs.FS
+
+	// OpenFile opens the named file for writing
+	// with the given os.O_APPEND, os.O_CREATE, and os.O_TRUNC flags.
+	OpenFile(name string, flag int) (io.WriteCloser, error)
+
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+}
+
+// DirFS returns an FS for the files in the given directory of the
+// operating system's file system. Names that refer to files outside the
+// directory, including by way of symbolic links, are rejected.
+func DirFS(dir string) FS { return dirFS{dir: dir} }
+
+type dirFS struct {
+	dir string
+}
+
+// errEscape is returned for names that refer to files outside an FS.
+var errEscape = errors.New("path escapes from file system root")
+
+// path returns the operating system path of the named file, checking
+// that it is within d. If follow is set, a symbolic link at that path
+// must also refer to a file within d.
+func (d dirFS) path(op, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p := filepath.Join(d.dir, filepath.FromSlash(name))
+	root, err := filepath.EvalSymlinks(d.dir)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	real := p
+	if follow {
+		real, err = filepath.EvalSymlinks(p)
+	}
+	if !follow || errors.Is(err, fs.ErrNotExist) {
+		// The file itself need not exist, but its directory must.
+		// A dangling link is checked by the file it refers to,
+		// as following it would create that file.
+		target := p
+		if follow {
+			target, err = linkTarget(p)
+		}
+		if err == nil {
+			dir, file := filepath.Split(target)
+			real, err = filepath.EvalSymlinks(dir)
+			real = filepath.Join(real, file)
+		}
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errEscape}
+	}
+	return p, nil
+}
+
+// linkTarget returns the name of the file that p refers to, following
+// any symbolic links at p itself. The file need not exist.
+func linkTarget(p string) (string, error) {
+	for i := 0; i < 40; i++ {
+		fi, err := os.Lstat(p)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			return p, nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		p = target
+	}
+	return "", errors.New("too many levels of symbolic links")
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	p, err := d.path("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.path("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.path("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (d dirFS) OpenFile(name string, flag int) (io.WriteCloser, error) {
+	p, err := d.path("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_WRONLY|flag, 0644)
+}
+
+func (d dirFS) Remove(name string) error {
+	// Remove a symbolic link itself, rather than the file it refers to.
+	p, err := d.path("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// ReadOnly returns an FS that reads the files of fsys
+// and refuses to write or remove them.
+func ReadOnly(fsys fs.FS) FS { return readOnlyFS{fsys} }
+
+// ZipFS returns a read-only FS for the files in a zip archive.
+func ZipFS(r *zip.Reader) FS { return ReadOnly(r) }
+
+type readOnlyFS struct {
+	fs.FS
+}
+
+func (readOnlyFS) OpenFile(name string, flag int) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func (readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+// Overlay returns an FS with the files of upper and, where upper has no
+// file of the same name, those of lower. Directories list the files of
+// both. Files are written to upper, having first been copied there from
+// lower if they exist there. If upper is a *MemFS, the directories of
+// files written to it are created as needed. Only the files of upper may
+// be removed.
+func Overlay(upper FS, lower fs.FS) FS { return overlayFS{upper, lower} }
+
+type overlayFS struct {
+	upper FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
+
+func (o overlayFS) Stat(name string) (fs.FileInfo, error) {
+	fi, err := fs.Stat(o.upper, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.Stat(o.lower, name)
+	}
+	return fi, err
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, err := fs.ReadDir(o.upper, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if lowerErr != nil {
+		if err != nil || !errors.Is(lowerErr, fs.ErrNotExist) {
+			return nil, lowerErr
+		}
+	}
+	des := upper
+	seen := map[string]bool{}
+	for _, de := range upper {
+		seen[de.Name()] = true
+	}
+	for _, de := range lower {
+		if !seen[de.Name()] {
+			des = append(des, de)
+		}
+	}
+	sort.Slice(des, func(i, j int) bool { return des[i].Name() < des[j].Name() })
+	return des, nil
+}
+
+func (o overlayFS) OpenFile(name string, flag int) (io.WriteCloser, error) {
+	_, err := fs.Stat(o.upper, name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return o.upper.OpenFile(name, flag)
+	}
+	data, err := fs.ReadFile(o.lower, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if m, ok := o.upper.(*MemFS); ok && (data != nil || flag&os.O_CREATE != 0) {
+		// Create the file and its directories in upper.
+		if err := m.WriteFile(name, data); err != nil {
+			return nil, err
+		}
+	} else if data != nil {
+		w, err := o.upper.OpenFile(name, os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(data)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o.upper.OpenFile(name, flag)
+}
+
+func (o overlayFS) Remove(name string) error {
+	err := o.upper.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, lerr := fs.Stat(o.lower, name); lerr == nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return err
+}
+
+// MemFS is an FS held in memory. Its zero value is an empty file system.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile // by name; the root directory is implicit
+}
+
+type memFile struct {
+	data []byte
+	dir  bool
+}
+
+// WriteFile creates the named file with the given contents,
+// and any directories that contain it.
+func (m *MemFS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = map[string]*memFile{}
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if f, ok := m.files[dir]; ok && !f.dir {
+			return &fs.PathError{Op: "write", Path: name, Err: errors.New("not a directory")}
+		}
+		m.files[dir] = &memFile{dir: true}
+	}
+	if f, ok := m.files[name]; ok && f.dir {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	}
+	m.files[name] = &memFile{data: append([]byte(nil), data...)}
+	return nil
+}
+
+// lookup returns the named file, or a directory if name is the root.
+// The caller must hold m.mu.
+func (m *MemFS) lookup(op, name string) (*memFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &memFile{dir: true}, nil
+	}
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := memInfo{name: path.Base(name), size: int64(len(f.data)), dir: f.dir}
+	if f.dir {
+		return &memDir{info: info, entries: m.readDir(name)}, nil
+	}
+	return &memReader{Reader: strings.NewReader(string(f.data)), info: info}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{name: path.Base(name), size: int64(len(f.data)), dir: f.dir}, nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return m.readDir(name), nil
+}
+
+// readDir returns the entries of the named directory, sorted by name.
+// The caller must hold m.mu.
+func (m *MemFS) readDir(dir string) []fs.DirEntry {
+	var des []fs.DirEntry
+	for name, f := range m.files {
+		if path.Dir(name) == dir {
+			info := memInfo{name: path.Base(name), size: int64(len(f.data)), dir: f.dir}
+			des = append(des, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(des, func(i, j int) bool { return des[i].Name() < des[j].Name() })
+	return des
+}
+
+func (m *MemFS) OpenFile(name string, flag int) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("open", name)
+	if errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0 {
+		if dir := path.Dir(name); dir != "." {
+			if d, ok := m.files[dir]; !ok || !d.dir {
+				return nil, err
+			}
+		}
+		if m.files == nil {
+			m.files = map[string]*memFile{}
+		}
+		f = &memFile{}
+		m.files[name] = f
+	} else if err != nil {
+		return nil, err
+	}
+	if f.dir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if flag&os.O_TRUNC != 0 {
+		f.data = nil
+	}
+	return &memWriter{fs: m, f: f, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." || f.dir && len(m.readDir(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string { return i.name }
+func (i memInfo) Size() int64  { return i.size }
+func (i memInfo) IsDir() bool  { return i.dir }
+func (i memInfo) Sys() any     { return nil }
+
+// ModTime returns the zero time, so that the file system's behavior
+// does not depend on the time at which it was used.
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+type memReader struct {
+	*strings.Reader
+	info memInfo
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+type memDir struct {
+	info    memInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		des := d.entries
+		d.entries = nil
+		return des, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	des := d.entries[:n]
+	d.entries = d.entries[n:]
+	return des, nil
+}
+
+type memWriter struct {
+	fs     *MemFS
+	f      *memFile
+	off    int
+	append bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	if w.append {
+		w.off = len(w.f.data)
+	}
+	if n := w.off + len(p); n > len(w.f.data) {
+		w.f.data = append(w.f.data, make([]byte, n-len(w.f.data))...)
+	}
+	copy(w.f.data[w.off:], p)
+	w.off += len(p)
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error { return nil }
@@ -0,0 +1,272 @@
+package varvara
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nf/nux/uxn"
+)
+
+func TestMemFS(t *testing.T) {
+	var m MemFS
+	if err := m.WriteFile("dir/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := m.OpenFile("dir/a.txt", os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, ", world")
+	w.Close()
+
+	w, err = m.OpenFile("b.txt", os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "abc")
+	w.Close()
+	w, err = m.OpenFile("b.txt", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "x")
+	w.Close()
+
+	for name, want := range map[string]string{"dir/a.txt": "hello, world", "b.txt": "xbc"} {
+		if b, err := fs.ReadFile(&m, name); err != nil || string(b) != want {
+			t.Errorf("ReadFile(%q) = %q, %v; want %q", name, b, err, want)
+		}
+	}
+
+	des, err := fs.ReadDir(&m, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if got, want := names, []string{"b.txt", "dir"}; !equalStrings(got, want) {
+		t.Errorf("ReadDir(.) = %q, want %q", got, want)
+	}
+
+	if _, err := m.OpenFile("missing/c.txt", os.O_CREATE); err == nil {
+		t.Error("creating file in missing directory succeeded")
+	}
+	if _, err := m.OpenFile("c.txt", 0); err == nil {
+		t.Error("opening missing file without O_CREATE succeeded")
+	}
+	if err := m.Remove("dir"); err == nil {
+		t.Error("removing non-empty directory succeeded")
+	}
+	if err := m.Remove("dir/a.txt"); err != nil {
+		t.Error(err)
+	}
+	if err := m.Remove("dir"); err != nil {
+		t.Error(err)
+	}
+	if _, err := m.Stat("dir"); err == nil {
+		t.Error("removed directory still exists")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lda pushes the byte at the given address.
+func lda(addr uint16) []byte {
+	return []byte{byte(uxn.LIT2), byte(addr >> 8), byte(addr), byte(uxn.LDA)}
+}
+
+func TestFileMemFS(t *testing.T) {
+	const (
+		nameAddr = spriteAddr
+		dataAddr = spriteAddr + 0x10
+		bufAddr  = spriteAddr + 0x20
+	)
+	data := make([]byte, 0x20)
+	copy(data, "out.txt\x00")
+	copy(data[0x10:], "hi")
+	rom := assemble(data,
+		// Write "hi" to out.txt, then read it back and print it.
+		deo2(0xa8, nameAddr), deo2(0xaa, 2), deo2(0xae, dataAddr),
+		deo2(0xa8, nameAddr), deo2(0xac, bufAddr),
+		lda(bufAddr), []byte{byte(uxn.LIT), 0x18, byte(uxn.DEO)},
+		lda(bufAddr+1), []byte{byte(uxn.LIT), 0x18, byte(uxn.DEO)},
+	)
+
+	var (
+		m   MemFS
+		out bytes.Buffer
+		r   = NewRunner(NoDisplay, false, nil)
+	)
+	r.SetOutput(&out)
+	r.SetFS(&m)
+	r.SetReplayInput(&InputRecording{})
+	r.Run(rom)
+	if got, want := out.String(), "hi"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if b, err := fs.ReadFile(&m, "out.txt"); err != nil || string(b) != "hi" {
+		t.Errorf("out.txt = %q, %v; want %q", b, err, "hi")
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("cannot make symbolic link: %v", err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "outside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "outside", "new"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("new.txt", filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := DirFS(root)
+	if b, err := fs.ReadFile(d, "a.txt"); err != nil || string(b) != "a" {
+		t.Errorf("ReadFile(a.txt) = %q, %v; want %q", b, err, "a")
+	}
+	for _, name := range []string{"../secret", "/etc/passwd", "link", "up/secret"} {
+		if _, err := fs.ReadFile(d, name); err == nil {
+			t.Errorf("ReadFile(%q) succeeded", name)
+		}
+		if _, err := d.OpenFile(name, os.O_CREATE); err == nil {
+			t.Errorf("OpenFile(%q) succeeded", name)
+		}
+	}
+	if _, err := d.OpenFile("up/new", os.O_CREATE); err == nil {
+		t.Error("created a file through a link out of the root")
+	}
+	if _, err := d.OpenFile("dangling", os.O_CREATE); err == nil {
+		t.Error("created a file through a dangling link out of the root")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "outside", "new")); err == nil {
+		t.Error("file created outside the root")
+	}
+	if w, err := d.OpenFile("inside", os.O_CREATE); err != nil {
+		t.Errorf("creating a file through a dangling link within the root: %v", err)
+	} else {
+		w.Close()
+	}
+	if err := d.Remove("link"); err != nil {
+		t.Errorf("removing link: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secret")); err != nil {
+		t.Errorf("removing link removed its target: %v", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	lower := fstest.MapFS{
+		"a.txt":     {Data: []byte("lower a")},
+		"dir/b.txt": {Data: []byte("lower b")},
+	}
+	var upper MemFS
+	o := Overlay(&upper, lower)
+
+	w, err := o.OpenFile("dir/b.txt", os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "!")
+	w.Close()
+	w, err = o.OpenFile("c.txt", os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "upper c")
+	w.Close()
+
+	for name, want := range map[string]string{"a.txt": "lower a", "dir/b.txt": "lower b!", "c.txt": "upper c"} {
+		if b, err := fs.ReadFile(o, name); err != nil || string(b) != want {
+			t.Errorf("ReadFile(%q) = %q, %v; want %q", name, b, err, want)
+		}
+	}
+	if b, _ := fs.ReadFile(lower, "dir/b.txt"); string(b) != "lower b" {
+		t.Errorf("lower dir/b.txt changed to %q", b)
+	}
+
+	des, err := fs.ReadDir(o, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if got, want := names, []string{"a.txt", "c.txt", "dir"}; !equalStrings(got, want) {
+		t.Errorf("ReadDir(.) = %q, want %q", got, want)
+	}
+
+	if err := o.Remove("a.txt"); err == nil {
+		t.Error("removing a file of lower succeeded")
+	}
+	if err := o.Remove("c.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZipFS(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hello")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := ZipFS(zr)
+	if b, err := fs.ReadFile(z, "dir/a.txt"); err != nil || string(b) != "hello" {
+		t.Errorf("ReadFile(dir/a.txt) = %q, %v; want %q", b, err, "hello")
+	}
+	if fi, err := fs.Stat(z, "dir"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(dir) = %v, %v; want a directory", fi, err)
+	}
+	if _, err := z.OpenFile("dir/a.txt", os.O_APPEND); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("OpenFile error = %v, want %v", err, fs.ErrPermission)
+	}
+	if err := z.Remove("dir/a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Remove error = %v, want %v", err, fs.ErrPermission)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"image/color"
 	"image/draw"
 	"log"
+	"math"
+	"sync"
 	"time"
 
 	"golang.org/x/exp/shiny/driver"
@@ -25,39 +27,66 @@ type Debugger interface {
 	Debug(cmd string, addr uint16)
 }
 
-func NewGUI(v *Varvara, d Debugger) *GUI {
-	up, done := make(chan bool), make(chan bool)
+// Scaling controls how a GUI window displays the Varvara screen.
+type Scaling struct {
+	// Scale is the factor by which the Varvara screen size is multiplied
+	// to determine the initial size of the window.
+	Scale int
+
+	// Integer specifies that the screen should only be scaled by whole
+	// multiples, leaving a border around it. Otherwise the screen is
+	// scaled to the largest size that fits the window.
+	Integer bool
+}
+
+// NewGUI returns a GUI for the given Varvara that maps keys
+// to the Controller device using k, or DefaultKeyMap if k is nil,
+// and delivers typed text as specified by t.
+func NewGUI(v *Varvara, d Debugger, s Scaling, k KeyMap, t TextInput) *GUI {
+	if s.Scale < 1 {
+		s.Scale = 1
+	}
+	if k == nil {
+		k = DefaultKeyMap()
+	}
 	return &GUI{
-		Update: up, doUpdate: up,
-		UpdateDone: done, updateDone: done,
-		v:     v,
-		debug: d,
+		frameSync: newFrameSync(),
+		v:         v,
+		debug:     d,
+		scaling:   s,
+		keys:      k,
+		text:      t,
+		title:     "nux",
 	}
 }
 
 type GUI struct {
-	Update     chan<- bool
-	UpdateDone <-chan bool
-
-	doUpdate   <-chan bool
-	updateDone chan<- bool
+	frameSync
 
-	v    *Varvara // only touch this in the update method!
+	v    *Varvara // only touch this in the update method, or to queue input events!
 	newV *Varvara // set after Swap, unset once swap happens
 
-	debug Debugger
+	debug   Debugger
+	scaling Scaling
+	keys    KeyMap
+	text    TextInput
+	title   string
 
 	ctrl  ControllerState
 	mouse MouseState
 
 	// Screen
-	wsize    size.Event
-	size     image.Point
-	fg, bg   screen.Buffer
-	tex      screen.Texture
-	xform    f64.Aff3 // from varvara buffer to window buffer
-	xformInv f64.Aff3
-	ops      int // updated to match v.scr.ops after copying fg/bg
+	wsize        size.Event
+	size         image.Point
+	fg, bg       screen.Buffer
+	fgTex, bgTex screen.Texture
+	xform        f64.Aff3 // from varvara buffer to window buffer
+	xformInv     f64.Aff3
+	fullscreen   bool // whether the window is the size of the display
+
+	// Regions of fg and bg that have changed since they were last
+	// uploaded to fgTex and bgTex.
+	fgDirty, bgDirty image.Rectangle
 }
 
 // Swap replaces the Varvara attached to GUI with the given one.
@@ -65,49 +94,184 @@ type GUI struct {
 // Swap may only be called when a GUI update is not in progress.
 func (g *GUI) Swap(v *Varvara) { g.newV = v }
 
+// Main runs f while providing a screen on which the GUIs of any Runners
+// that f runs open their windows, so that several Runners may each have
+// a window. Some platforms require windows to be driven from the main
+// thread, so Main should be called from the main goroutine, and f
+// should run the Runners in other goroutines and wait for them.
+// A Runner's GUI otherwise drives the windowing system itself,
+// which may only be done by one Runner at a time.
+func Main(f func()) {
+	driver.Main(func(s screen.Screen) {
+		shared.Lock()
+		shared.s = s
+		shared.Unlock()
+		defer func() {
+			shared.Lock()
+			shared.s = nil
+			shared.Unlock()
+		}()
+		f()
+	})
+}
+
+// shared holds the screen provided by Main while it is running.
+var shared struct {
+	sync.Mutex
+	s screen.Screen
+}
+
+// mainScreen returns the screen provided by Main, or nil if
+// Main is not running.
+func mainScreen() screen.Screen {
+	shared.Lock()
+	defer shared.Unlock()
+	return shared.s
+}
+
 type updateEvent struct{}
 
-var errCloseGUI = errors.New("close GUI")
+var (
+	errCloseGUI         = errors.New("close GUI")
+	errToggleFullscreen = errors.New("toggle fullscreen")
+)
 
 func (g *GUI) Run(exit <-chan bool) (err error) {
 	defer close(g.updateDone)
-	driver.Main(func(s screen.Screen) {
-		var w screen.Window
-		w, err = s.NewWindow(&screen.NewWindowOptions{Title: "nux"})
-		if err != nil {
-			return
+	if s := mainScreen(); s != nil {
+		err = g.run(s, exit)
+	} else {
+		driver.Main(func(s screen.Screen) { err = g.run(s, exit) })
+	}
+	if err == errCloseGUI {
+		err = nil
+	}
+	return
+}
+
+// run opens a window on s and handles its events until exit is closed
+// or the window is closed.
+func (g *GUI) run(s screen.Screen, exit <-chan bool) (err error) {
+	// Wait for the reset vector to complete so that the window
+	// can be sized to match the screen size that it sets.
+	// Give up after a while in case it is paused by the debugger
+	// or stuck in a loop.
+	updating := false
+	select {
+	case <-g.doUpdate:
+		updating = true
+	case <-time.After(time.Second):
+	case <-exit:
+		return
+	}
+	size := image.Point{0x100, 0x100}
+	if updating {
+		if w, h := int(g.v.scr.Width()), int(g.v.scr.Height()); w > 0 && h > 0 {
+			size = image.Point{w, h}
 		}
-		defer w.Release()
-		defer g.release()
-
-		go func() {
-			t := time.NewTicker(time.Second / 60)
-			defer t.Stop()
-			for {
-				select {
-				case <-t.C:
-					w.Send(updateEvent{})
-				case <-exit:
-					return
-				}
-			}
-		}()
+	}
+	size = size.Mul(g.scaling.Scale)
 
-		for err == nil {
+	var w screen.Window
+	w, err = s.NewWindow(g.windowOptions(size))
+	if updating {
+		if err == nil {
+			err = g.update(s)
+		}
+		g.updateDone <- true
+	}
+	if err != nil {
+		return
+	}
+	defer func() { w.Release() }()
+	defer g.release()
+
+	// The ticker sends update events to the current window,
+	// which changes when fullscreen is toggled.
+	windows := make(chan screen.Window)
+	go func() {
+		t := time.NewTicker(time.Second / 60)
+		defer t.Stop()
+		w := w
+		for {
 			select {
+			case w = <-windows:
+			case <-t.C:
+				w.Send(updateEvent{})
 			case <-exit:
 				return
-			default:
 			}
-			err = g.handle(s, w, w.NextEvent())
 		}
-	})
-	if err == errCloseGUI {
-		err = nil
+	}()
+
+	for err == nil {
+		select {
+		case <-exit:
+			return
+		default:
+		}
+		err = g.handle(s, w, w.NextEvent())
+		if err == errToggleFullscreen {
+			w, err = g.toggleFullscreen(s, w, size)
+			select {
+			case windows <- w:
+			case <-exit:
+			}
+		}
 	}
 	return
 }
 
+func (g *GUI) windowOptions(size image.Point) *screen.NewWindowOptions {
+	return &screen.NewWindowOptions{
+		Title:  g.title,
+		Width:  size.X,
+		Height: size.Y,
+	}
+}
+
+// toggleFullscreen replaces w with a window the size of the display,
+// or if the GUI is fullscreen, with a window of the given size.
+// As shiny can neither resize a window nor make it fullscreen,
+// the new window is opened in its place and w is released.
+// If the new window cannot be opened, w is kept and the error logged.
+func (g *GUI) toggleFullscreen(s screen.Screen, w screen.Window, size image.Point) (screen.Window, error) {
+	if !g.fullscreen {
+		d, err := displaySize()
+		if err != nil {
+			log.Printf("gui: fullscreen: %v", err)
+			return w, nil
+		}
+		size = d
+	}
+	nw, err := s.NewWindow(g.windowOptions(size))
+	if err != nil {
+		log.Printf("gui: fullscreen: %v", err)
+		return w, nil
+	}
+	g.fullscreen = !g.fullscreen
+
+	// Textures may belong to the window they were made with (gldriver
+	// makes them in a window's GL context), so remake them for the
+	// new window from the buffers, which belong to the screen.
+	if g.bgTex != nil {
+		g.fgTex.Release()
+		g.bgTex.Release()
+		g.fgTex, g.bgTex = nil, nil
+	}
+	w.Release()
+	if g.bg != nil {
+		if g.fgTex, err = s.NewTexture(g.size); err != nil {
+			return nw, err
+		}
+		if g.bgTex, err = s.NewTexture(g.size); err != nil {
+			return nw, err
+		}
+		g.fgDirty, g.bgDirty = g.fg.Bounds(), g.bg.Bounds()
+	}
+	return nw, nil
+}
+
 func (g *GUI) handle(s screen.Screen, w screen.Window, e any) error {
 	if debugGUI {
 		switch e := e.(type) {
@@ -139,6 +303,9 @@ func (g *GUI) handle(s screen.Screen, w screen.Window, e any) error {
 		}
 
 	case key.Event:
+		if e.Code == key.CodeF11 && e.Direction == key.DirPress {
+			return errToggleFullscreen
+		}
 		g.handleKey(e)
 
 	case mouse.Event:
@@ -176,15 +343,13 @@ func (g *GUI) update(s screen.Screen) (err error) {
 		resetScreen = true
 	}
 
-	g.v.cntrl.Set(&g.ctrl)
-	g.v.mouse.Set(&g.mouse)
-
 	// Screen
 	g.size = image.Point{int(g.v.scr.Width()), int(g.v.scr.Height())}
 	if g.size.X == 0 || g.size.Y == 0 {
 		g.size = image.Point{0x100, 0x100}
 	}
-	if resetScreen || g.tex == nil || g.tex.Size() != g.size {
+	full := false
+	if resetScreen || g.bgTex == nil || g.bgTex.Size() != g.size {
 		g.release()
 		g.fg, err = s.NewBuffer(g.size)
 		if err != nil {
@@ -194,33 +359,53 @@ func (g *GUI) update(s screen.Screen) (err error) {
 		if err != nil {
 			return
 		}
-		g.tex, err = s.NewTexture(g.size)
+		g.fgTex, err = s.NewTexture(g.size)
+		if err != nil {
+			return
+		}
+		g.bgTex, err = s.NewTexture(g.size)
 		if err != nil {
 			return
 		}
-		g.ops = -1
+		full = true
 		g.updateTransform()
 	}
-	if o := g.v.scr.ops; g.ops != o {
-		if m := g.v.scr.fg; m != nil && m.Bounds().Size() == g.size {
-			copy(g.fg.RGBA().Pix, m.Pix)
-		}
-		if m := g.v.scr.bg; m != nil && m.Bounds().Size() == g.size {
-			copy(g.bg.RGBA().Pix, m.Pix)
-		}
-		g.ops = o
+	fgr, bgr := g.v.scr.takeDirty()
+	if full {
+		fgr, bgr = g.fg.Bounds(), g.bg.Bounds()
+	}
+	if m := g.v.scr.fg; m != nil && m.Bounds().Size() == g.size {
+		copyRect(g.fg.RGBA(), m, fgr)
+		g.fgDirty = g.fgDirty.Union(fgr)
+	}
+	if m := g.v.scr.bg; m != nil && m.Bounds().Size() == g.size {
+		copyRect(g.bg.RGBA(), m, bgr)
+		g.bgDirty = g.bgDirty.Union(bgr)
 	}
 	return
 }
 
+// copyRect copies the pixels within r from src to dst.
+func copyRect(dst, src *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(dst.Bounds()).Intersect(src.Bounds())
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i, j := dst.PixOffset(r.Min.X, y), src.PixOffset(r.Min.X, y)
+		copy(dst.Pix[i:i+n], src.Pix[j:j+n])
+	}
+}
+
 func (g *GUI) updateTransform() {
-	g.xform = paintTransform(g.wsize.Bounds(), g.bg.Bounds())
+	g.xform = paintTransform(g.wsize.Bounds(), g.bg.Bounds(), g.scaling.Integer)
 	g.xformInv = invert(g.xform)
 }
 
 func (g *GUI) release() {
-	if g.tex != nil {
-		g.tex.Release()
+	if g.fgTex != nil {
+		g.fgTex.Release()
+	}
+	if g.bgTex != nil {
+		g.bgTex.Release()
 	}
 	if g.fg != nil {
 		g.fg.Release()
@@ -233,18 +418,25 @@ func (g *GUI) release() {
 // paint draws bg and fg to the given window.
 func (g *GUI) paint(w screen.Window) {
 	w.Fill(g.wsize.Bounds(), color.RGBA{0, 0, 0, 0}, draw.Src)
-	if g.bg != nil { // fg, tex, and xform must also be set
-		g.tex.Upload(image.Point{}, g.bg, g.bg.Bounds())
-		w.Draw(g.xform, g.tex, g.tex.Bounds(), draw.Src, nil)
-		g.tex.Upload(image.Point{}, g.fg, g.fg.Bounds())
-		w.Draw(g.xform, g.tex, g.tex.Bounds(), draw.Over, nil)
+	if g.bg != nil { // fg, textures, and xform must also be set
+		if r := g.bgDirty; !r.Empty() {
+			g.bgTex.Upload(r.Min, g.bg, r)
+		}
+		if r := g.fgDirty; !r.Empty() {
+			g.fgTex.Upload(r.Min, g.fg, r)
+		}
+		g.fgDirty, g.bgDirty = image.Rectangle{}, image.Rectangle{}
+		w.Draw(g.xform, g.bgTex, g.bgTex.Bounds(), draw.Src, nil)
+		w.Draw(g.xform, g.fgTex, g.fgTex.Bounds(), draw.Over, nil)
 	}
 	w.Publish()
 }
 
 // paintTransform returns the affine transform that maps the pixels in the
 // source to the largest rectangle that fits inside the destination.
-func paintTransform(dst, src image.Rectangle) f64.Aff3 {
+// If integer is true then the source is only scaled by a whole multiple,
+// unless it is too large to fit at its original size.
+func paintTransform(dst, src image.Rectangle, integer bool) f64.Aff3 {
 	var (
 		wx, wy = float64(dst.Dx()), float64(dst.Dy())
 		sx, sy = float64(src.Dx()), float64(src.Dy())
@@ -257,6 +449,9 @@ func paintTransform(dst, src image.Rectangle) f64.Aff3 {
 	} else {
 		dx, dy = wx, wx/sr
 	}
+	if n := math.Floor(dx / sx); integer && n >= 1 {
+		dx, dy = sx*n, sy*n
+	}
 	return f64.Aff3{
 		dx / sx, 0, (wx - dx) / 2,
 		0, dy / sy, (wy - dy) / 2,
@@ -278,41 +473,48 @@ func (g *GUI) handleKey(e key.Event) {
 		case key.CodeF7:
 			g.debug.Debug("halt", 0)
 			return
+		case key.CodeF2:
+			g.scaling.Integer = !g.scaling.Integer
+			if g.bg != nil {
+				g.updateTransform()
+			}
+			return
 		}
 	}
 	var (
-		s = &g.ctrl
-		b = e.Direction == key.DirPress || e.Direction == 10
+		s    = &g.ctrl
+		down = keyDown(e)
+		bind = g.keys[e.Code]
+		r    rune // typed non-ASCII rune
 	)
-	switch e.Code {
-	case key.CodeLeftControl:
-		s.A = b
-	case key.CodeLeftAlt:
-		s.B = b
-	case key.CodeLeftShift:
-		s.Select = b
-	case key.CodeHome:
-		s.Start = b
-	case key.CodeUpArrow:
-		s.Up = b
-	case key.CodeDownArrow:
-		s.Down = b
-	case key.CodeLeftArrow:
-		s.Left = b
-	case key.CodeRightArrow:
-		s.Right = b
-	}
-	if 0 <= e.Rune && e.Rune < 0x80 {
-		k := byte(e.Rune)
-		if e.Modifiers&key.ModControl != 0 && 'A'-0x40 <= k && k <= 'Z'-0x40 {
-			if e.Modifiers&key.ModShift != 0 {
-				k += 0x40
-			} else {
-				k += 0x60
+	if bind.Button != 0 {
+		*s.player(bind.Player).button(bind.Button) = down
+	}
+	if down {
+		if bind.Key != 0 {
+			s.Key = bind.Key
+		} else if 0 <= e.Rune && e.Rune < 0x80 {
+			k := byte(e.Rune)
+			if e.Modifiers&key.ModControl != 0 && 'A'-0x40 <= k && k <= 'Z'-0x40 {
+				if e.Modifiers&key.ModShift != 0 {
+					k += 0x40
+				} else {
+					k += 0x60
+				}
 			}
+			s.Key = k
+		} else if e.Rune >= 0x80 {
+			r = e.Rune
 		}
-		s.Key = k
 	}
+	sendKey(g.v, s, g.text, r)
+}
+
+// keyDown reports whether e is a key press or repeat. The cocoa driver
+// reports modifier key presses and releases with the directions 10 and 11
+// (NSKeyDown and NSKeyUp).
+func keyDown(e key.Event) bool {
+	return e.Direction != key.DirRelease && e.Direction != 11
 }
 
 func (g *GUI) handleMouse(e mouse.Event) {
@@ -331,6 +533,22 @@ func (g *GUI) handleMouse(e mouse.Event) {
 	if e.Button >= 1 && e.Button <= 3 && e.Direction != mouse.DirNone {
 		m.Button[e.Button-1] = e.Direction == mouse.DirPress
 	}
+	if e.Button.IsWheel() && e.Direction != mouse.DirRelease {
+		s := *m
+		switch e.Button {
+		case mouse.ButtonWheelUp:
+			s.ScrollY = -1
+		case mouse.ButtonWheelDown:
+			s.ScrollY = 1
+		case mouse.ButtonWheelLeft:
+			s.ScrollX = -1
+		case mouse.ButtonWheelRight:
+			s.ScrollX = 1
+		}
+		g.v.mouse.Set(&s)
+		return
+	}
+	g.v.mouse.Set(m)
 }
 
 func clampInt16(v float64) int16 {
@@ -0,0 +1,31 @@
+package varvara
+
+import (
+	"image"
+	"testing"
+
+	"golang.org/x/image/math/f64"
+)
+
+func TestPaintTransform(t *testing.T) {
+	r := image.Rect
+	for _, c := range []struct {
+		dst, src image.Rectangle
+		integer  bool
+		want     f64.Aff3
+	}{
+		// Fit, letterboxed horizontally and vertically.
+		{r(0, 0, 600, 400), r(0, 0, 100, 100), false, f64.Aff3{4, 0, 100, 0, 4, 0}},
+		{r(0, 0, 400, 600), r(0, 0, 100, 100), false, f64.Aff3{4, 0, 0, 0, 4, 100}},
+		{r(0, 0, 250, 250), r(0, 0, 100, 100), false, f64.Aff3{2.5, 0, 0, 0, 2.5, 0}},
+		// Integer scaling rounds down and centers.
+		{r(0, 0, 250, 250), r(0, 0, 100, 100), true, f64.Aff3{2, 0, 25, 0, 2, 25}},
+		{r(0, 0, 600, 400), r(0, 0, 100, 100), true, f64.Aff3{4, 0, 100, 0, 4, 0}},
+		// Unless the window is smaller than the screen.
+		{r(0, 0, 50, 50), r(0, 0, 100, 100), true, f64.Aff3{0.5, 0, 0, 0, 0.5, 0}},
+	} {
+		if got := paintTransform(c.dst, c.src, c.integer); got != c.want {
+			t.Errorf("paintTransform(%v, %v, %v) = %v, want %v", c.dst, c.src, c.integer, got, c.want)
+		}
+	}
+}
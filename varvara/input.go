@@ -1,23 +1,71 @@
 package varvara
 
-type inputDevice struct {
-	Ready <-chan bool
+import "sync"
+
+// inputDevice implements the event queue for a device whose vector is
+// triggered by user input, such as the Controller and Mouse.
+//
+// Events may be queued from any goroutine, and are delivered to the
+// device one at a time by the Varvara CPU, so that each event triggers
+// the device's vector in order and no transitions are lost.
+type inputDevice[T any] struct {
+	Ready <-chan bool // receives when events are queued
 
 	mem   deviceMem
 	ready chan bool
+
+	mu    sync.Mutex
+	queue []T
+
+	record func(T) // if non-nil, called with each event delivered
+}
+
+// maxQueuedEvents limits the size of the event queue, to bound memory
+// use while the Varvara CPU is paused. When the queue is full the oldest
+// events are dropped.
+const maxQueuedEvents = 1024
+
+func (d *inputDevice[T]) init() {
+	d.ready = make(chan bool, 1)
+	d.Ready = d.ready
 }
 
-func (d *inputDevice) Vector() uint16 { return d.mem.short(0x0) }
-func (d *inputDevice) In(p byte) byte { return d.mem[p] }
-func (d *inputDevice) Out(p, b byte) {
-	if p&0x1 == p && d.ready == nil {
-		d.ready = make(chan bool, 1)
-		d.Ready = d.ready
+func (d *inputDevice[T]) Vector() uint16 { return d.mem.short(0x0) }
+func (d *inputDevice[T]) In(p byte) byte { return d.mem[p] }
+func (d *inputDevice[T]) Out(p, b byte)  { d.mem[p] = b }
+
+// push adds e to the end of the event queue.
+func (d *inputDevice[T]) push(e T) {
+	d.mu.Lock()
+	if len(d.queue) >= maxQueuedEvents {
+		d.queue = d.queue[1:]
+	}
+	d.queue = append(d.queue, e)
+	d.mu.Unlock()
+	d.signal()
+}
+
+// pop removes and returns the event at the front of the queue.
+func (d *inputDevice[T]) pop() (e T, ok bool) {
+	d.mu.Lock()
+	if len(d.queue) == 0 {
+		d.mu.Unlock()
+		return e, false
+	}
+	e = d.queue[0]
+	d.queue = d.queue[1:]
+	more := len(d.queue) > 0
+	d.mu.Unlock()
+	if more {
+		d.signal()
+	}
+	if d.record != nil {
+		d.record(e)
 	}
-	d.mem[p] = b
+	return e, true
 }
 
-func (d *inputDevice) updated() {
+func (d *inputDevice[T]) signal() {
 	select {
 	case d.ready <- true:
 	default:
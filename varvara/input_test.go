@@ -0,0 +1,146 @@
+package varvara
+
+import "testing"
+
+func TestControllerQueue(t *testing.T) {
+	var c Controller
+	c.init()
+	c.mem.setShort(0x0, 0x1234) // vector
+
+	// A press and release of a button and a key within one frame,
+	// followed by two presses of the same key.
+	for _, s := range []ControllerState{
+		{Buttons: Buttons{A: true}},
+		{Buttons: Buttons{A: true}, Key: 'x'},
+		{},
+		{Key: 'y'},
+		{Key: 'y'},
+	} {
+		c.Set(&s)
+	}
+	for i, want := range []struct {
+		button, key byte
+	}{
+		{0x01, 0},
+		{0x01, 'x'},
+		{0x00, 0},
+		{0x00, 'y'},
+		{0x00, 'y'},
+	} {
+		select {
+		case <-c.Ready:
+		default:
+			t.Fatalf("event %d: Ready not signaled", i)
+		}
+		if vec := c.next(); vec != 0x1234 {
+			t.Errorf("event %d: vector = %.4x, want 1234", i, vec)
+		}
+		if b, k := c.In(0x2), c.In(0x3); b != want.button || k != want.key {
+			t.Errorf("event %d: button, key = %.2x, %q; want %.2x, %q", i, b, k, want.button, want.key)
+		}
+		c.clearKey()
+	}
+	select {
+	case <-c.Ready:
+		t.Fatal("Ready signaled with empty queue")
+	default:
+	}
+}
+
+func TestControllerPlayers(t *testing.T) {
+	var c Controller
+	c.init()
+	c.mem.setShort(0x0, 0x1234) // vector
+
+	var s ControllerState
+	s.Start = true
+	s.Player[0].A = true
+	s.Player[2].Left = true
+	c.Set(&s)
+	if vec := c.next(); vec != 0x1234 {
+		t.Errorf("vector = %.4x, want 1234", vec)
+	}
+	for port, want := range map[byte]byte{0x2: 0x08, 0x5: 0x01, 0x6: 0x00, 0x7: 0x40} {
+		if got := c.In(port); got != want {
+			t.Errorf("port %x = %.2x, want %.2x", port, got, want)
+		}
+	}
+
+	// A change to only another player's buttons also fires the vector.
+	s.Player[1].B = true
+	c.Set(&s)
+	if vec := c.next(); vec != 0x1234 {
+		t.Errorf("after player 3 press, vector = %.4x, want 1234", vec)
+	}
+	if got := c.In(0x6); got != 0x02 {
+		t.Errorf("port 6 = %.2x, want 02", got)
+	}
+}
+
+func TestMouseQueue(t *testing.T) {
+	var m Mouse
+	m.init()
+	m.mem.setShort(0x0, 0x1234) // vector
+
+	// A click within one frame, followed by an event that doesn't
+	// change the state and so shouldn't trigger the vector.
+	for _, s := range []MouseState{
+		{X: 1, Y: 2},
+		{X: 1, Y: 2, Button: [3]bool{true}},
+		{X: 1, Y: 2},
+		{X: 1, Y: 2},
+	} {
+		m.Set(&s)
+	}
+	for i, want := range []struct {
+		vector uint16
+		button byte
+	}{
+		{0x1234, 0x00},
+		{0x1234, 0x01},
+		{0x1234, 0x00},
+		{0x0000, 0x00},
+	} {
+		<-m.Ready
+		if vec := m.next(); vec != want.vector {
+			t.Errorf("event %d: vector = %.4x, want %.4x", i, vec, want.vector)
+		}
+		if b := m.In(0x6); b != want.button {
+			t.Errorf("event %d: button = %.2x, want %.2x", i, b, want.button)
+		}
+	}
+}
+
+func TestMouseScroll(t *testing.T) {
+	var m Mouse
+	m.init()
+	m.mem.setShort(0x0, 0x1234) // vector
+
+	// Two identical scroll steps each trigger the vector,
+	// and the scroll ports are reset after each.
+	for _, s := range []MouseState{
+		{X: 1, ScrollY: -1},
+		{X: 1, ScrollY: -1},
+		{X: 1, ScrollX: 1},
+	} {
+		m.Set(&s)
+	}
+	for i, want := range []struct{ x, y int16 }{
+		{0, -1},
+		{0, -1},
+		{1, 0},
+	} {
+		<-m.Ready
+		if vec := m.next(); vec != 0x1234 {
+			t.Errorf("event %d: vector = %.4x, want 1234", i, vec)
+		}
+		x, y := int16(m.mem.short(0xa)), int16(m.mem.short(0xc))
+		if x != want.x || y != want.y {
+			t.Errorf("event %d: scroll = %d, %d; want %d, %d", i, x, y, want.x, want.y)
+		}
+		m.clearScroll()
+		if x, y := m.mem.short(0xa), m.mem.short(0xc); x != 0 || y != 0 {
+			t.Errorf("event %d: after clearScroll, scroll = %d, %d; want 0, 0", i, x, y)
+		}
+	}
+}
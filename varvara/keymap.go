@@ -0,0 +1,145 @@
+package varvara
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mobile/event/key"
+)
+
+// KeyMap maps keyboard keys to their effect on the Controller device.
+type KeyMap map[key.Code]KeyBinding
+
+// KeyBinding describes the effect of a key on the Controller device.
+type KeyBinding struct {
+	Player int  // The player whose Button is pressed, from 0 to 3.
+	Button byte // A button mask, as reported by the device, or zero.
+	Key    byte // The value to report on the key port, or zero.
+}
+
+// DefaultKeyMap returns the key map used if none is specified.
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		key.CodeLeftControl:  {Button: buttonA},
+		key.CodeRightControl: {Button: buttonA},
+		key.CodeLeftAlt:      {Button: buttonB},
+		key.CodeRightAlt:     {Button: buttonB},
+		key.CodeLeftShift:    {Button: buttonSelect},
+		key.CodeRightShift:   {Button: buttonSelect},
+		key.CodeHome:         {Button: buttonStart},
+		key.CodeUpArrow:      {Button: buttonUp},
+		key.CodeDownArrow:    {Button: buttonDown},
+		key.CodeLeftArrow:    {Button: buttonLeft},
+		key.CodeRightArrow:   {Button: buttonRight},
+
+		key.CodeDeleteBackspace: {Key: 0x08},
+		key.CodeTab:             {Key: 0x09},
+		key.CodeReturnEnter:     {Key: 0x0d},
+		key.CodeKeypadEnter:     {Key: 0x0d},
+		key.CodeEscape:          {Key: 0x1b},
+		key.CodeDeleteForward:   {Key: 0x7f},
+	}
+}
+
+// Set binds the named key to the given target.
+//
+// Key names are those of the golang.org/x/mobile/event/key Code constants
+// without their "Code" prefix, such as "LeftControl", "A", or "UpArrow",
+// and are not case sensitive.
+//
+// The target is a button name ("a", "b", "select", "start", "up", "down",
+// "left", or "right"), optionally prefixed by a player ("p1." to "p4.");
+// or "key:" followed by a hexadecimal value for the key port; or "none",
+// to remove any existing binding.
+func (m KeyMap) Set(name, target string) error {
+	code, ok := keyCodes[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown key %q", name)
+	}
+	t := strings.ToLower(target)
+	if t == "none" {
+		delete(m, code)
+		return nil
+	}
+	if v, ok := strings.CutPrefix(t, "key:"); ok {
+		k, err := strconv.ParseUint(v, 16, 8)
+		if err != nil || k == 0 {
+			return fmt.Errorf("bad key value %q", v)
+		}
+		m[code] = KeyBinding{Key: byte(k)}
+		return nil
+	}
+	var b KeyBinding
+	if p, button, ok := strings.Cut(t, "."); ok {
+		n, err := strconv.Atoi(strings.TrimPrefix(p, "p"))
+		if !strings.HasPrefix(p, "p") || err != nil || n < 1 || n > 4 {
+			return fmt.Errorf("bad player %q", p)
+		}
+		b.Player = n - 1
+		t = button
+	}
+	b.Button, ok = buttonNames[t]
+	if !ok {
+		return fmt.Errorf("unknown button %q", target)
+	}
+	m[code] = b
+	return nil
+}
+
+// Load reads key bindings from r and adds them to m.
+// Each line of the input contains a key name and a target, as accepted by
+// Set, separated by white space. Blank lines and those beginning with '#'
+// are ignored.
+func (m KeyMap) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return fmt.Errorf("line %d: want key and target, got %q", n, line)
+		}
+		if err := m.Set(f[0], f[1]); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return s.Err()
+}
+
+const (
+	buttonA byte = 1 << iota
+	buttonB
+	buttonSelect
+	buttonStart
+	buttonUp
+	buttonDown
+	buttonLeft
+	buttonRight
+)
+
+var buttonNames = map[string]byte{
+	"a":      buttonA,
+	"b":      buttonB,
+	"select": buttonSelect,
+	"start":  buttonStart,
+	"up":     buttonUp,
+	"down":   buttonDown,
+	"left":   buttonLeft,
+	"right":  buttonRight,
+}
+
+// keyCodes maps lower case key names to key codes.
+var keyCodes = func() map[string]key.Code {
+	m := map[string]key.Code{}
+	for c := key.Code(1); c <= key.CodeRightGUI; c++ {
+		if name, ok := strings.CutPrefix(c.String(), "Code"); ok && !strings.HasPrefix(name, "(") {
+			m[strings.ToLower(name)] = c
+		}
+	}
+	return m
+}()
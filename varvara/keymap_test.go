@@ -0,0 +1,75 @@
+package varvara
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/mobile/event/key"
+)
+
+func TestKeyMapSet(t *testing.T) {
+	for _, c := range []struct {
+		name, target string
+		code         key.Code
+		want         KeyBinding
+	}{
+		{"Z", "a", key.CodeZ, KeyBinding{Button: buttonA}},
+		{"leftcontrol", "Start", key.CodeLeftControl, KeyBinding{Button: buttonStart}},
+		{"W", "p2.up", key.CodeW, KeyBinding{Player: 1, Button: buttonUp}},
+		{"Keypad4", "p4.left", key.CodeKeypad4, KeyBinding{Player: 3, Button: buttonLeft}},
+		{"F1", "key:1b", key.CodeF1, KeyBinding{Key: 0x1b}},
+	} {
+		m := KeyMap{}
+		if err := m.Set(c.name, c.target); err != nil {
+			t.Errorf("Set(%q, %q): %v", c.name, c.target, err)
+			continue
+		}
+		if got := m[c.code]; got != c.want {
+			t.Errorf("Set(%q, %q) bound %v, want %v", c.name, c.target, got, c.want)
+		}
+	}
+
+	for _, c := range []struct{ name, target string }{
+		{"NoSuchKey", "a"},
+		{"A", "c"},
+		{"A", "p5.a"},
+		{"A", "x1.a"},
+		{"A", "key:100"},
+		{"A", "key:0"},
+	} {
+		if err := (KeyMap{}).Set(c.name, c.target); err == nil {
+			t.Errorf("Set(%q, %q) succeeded, want error", c.name, c.target)
+		}
+	}
+}
+
+func TestKeyMapLoad(t *testing.T) {
+	m := DefaultKeyMap()
+	err := m.Load(strings.NewReader(`
+# Player 2 on the left of the keyboard.
+W p2.up
+S p2.down
+
+Home none
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m[key.CodeW], (KeyBinding{Player: 1, Button: buttonUp}); got != want {
+		t.Errorf("W bound to %v, want %v", got, want)
+	}
+	if got, want := m[key.CodeS], (KeyBinding{Player: 1, Button: buttonDown}); got != want {
+		t.Errorf("S bound to %v, want %v", got, want)
+	}
+	if b, ok := m[key.CodeHome]; ok {
+		t.Errorf("Home bound to %v, want no binding", b)
+	}
+	if got, want := m[key.CodeUpArrow], (KeyBinding{Button: buttonUp}); got != want {
+		t.Errorf("UpArrow bound to %v, want %v", got, want)
+	}
+
+	err = m.Load(strings.NewReader("A a\nB\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Load with bad line 2 returned %v, want line 2 error", err)
+	}
+}
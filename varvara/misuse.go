@@ -0,0 +1,47 @@
+package varvara
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nf/nux/uxn"
+)
+
+// A Misuse describes a program's misuse of a device, such as reading a
+// file before setting its name. The device operation fails, reporting
+// failure on the device's success port if it has one.
+type Misuse struct {
+	Device string // such as "file"
+	Port   byte   // the port that was written
+	Addr   uint16 // the address of the instruction that wrote it
+	Msg    string
+}
+
+func (m Misuse) String() string {
+	return fmt.Sprintf("%s: %s (port %.2x, at %.4x)", m.Device, m.Msg, m.Port, m.Addr)
+}
+
+// MisuseHalt is the halt code passed to the System device's halt vector
+// when a device is misused and the Runner is set to halt on misuse.
+const MisuseHalt uxn.HaltCode = 0x04
+
+// misuseFunc returns a function that reports the misuse of port p of
+// the named device, whose ports start at base.
+func (v *Varvara) misuseFunc(device string, base byte) func(p byte, msg string) {
+	return func(p byte, msg string) {
+		m := Misuse{
+			Device: device,
+			Port:   base | p,
+			Addr:   v.m.PC - 1, // the DEO instruction
+			Msg:    msg,
+		}
+		if v.onMisuse != nil {
+			v.onMisuse(m)
+		} else {
+			log.Print(m)
+		}
+		if v.haltOnMisuse {
+			panic(MisuseHalt)
+		}
+	}
+}
@@ -1,22 +1,45 @@
 package varvara
 
 type Mouse struct {
-	inputDevice
+	inputDevice[MouseState]
 }
 
 type MouseState struct {
-	X, Y             int16
+	X, Y int16
+
+	// ScrollX and ScrollY are the scroll wheel movement since the
+	// last event. Positive values are to the right and down.
+	// The device reports each scroll movement once.
 	ScrollX, ScrollY int16
-	Button           [3]bool
+
+	Button [3]bool
+}
+
+// Set queues an event that changes the mouse state to s.
+func (m *Mouse) Set(s *MouseState) { m.push(*s) }
+
+// next delivers the next queued event to the device and returns the
+// mouse vector if the event changed the mouse's state.
+func (m *Mouse) next() uint16 {
+	s, ok := m.pop()
+	if !ok || !m.apply(&s) {
+		return 0
+	}
+	return m.Vector()
 }
 
-func (m *Mouse) Set(s *MouseState) {
+// apply writes s to the device memory
+// and reports whether it changed the device state.
+func (m *Mouse) apply(s *MouseState) bool {
 	u := false
 
 	u = m.mem.setShortChanged(0x2, uint16(s.X)) || u
 	u = m.mem.setShortChanged(0x4, uint16(s.Y)) || u
-	u = m.mem.setShortChanged(0xa, uint16(s.ScrollX)) || u
-	u = m.mem.setShortChanged(0xc, uint16(s.ScrollY)) || u
+	if s.ScrollX != 0 || s.ScrollY != 0 {
+		m.mem.setShort(0xa, uint16(s.ScrollX))
+		m.mem.setShort(0xc, uint16(s.ScrollY))
+		u = true
+	}
 
 	var b byte
 	if s.Button[0] {
@@ -30,7 +53,11 @@ func (m *Mouse) Set(s *MouseState) {
 	}
 	u = m.mem.setChanged(0x6, b) || u
 
-	if u {
-		m.updated()
-	}
+	return u
+}
+
+// clearScroll resets the scroll ports once a scroll has been handled.
+func (m *Mouse) clearScroll() {
+	m.mem.setShort(0xa, 0)
+	m.mem.setShort(0xc, 0)
 }
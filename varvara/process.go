@@ -0,0 +1,174 @@
+package varvara
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// The Console device can run host commands, as in uxn11, if they are
+// allowed by Runner.SetAllowedCommands. Its extra ports are:
+//
+//	0x5 live: 0x00 if no command was started, 0x01 while it runs,
+//	          and 0xff once it has exited
+//	0x6 exit: the exit status of the command, once it has exited
+//	0xc addr: the address of the command, a NUL-terminated string
+//	0xe mode: the bits below
+//	0xf exec: writing any value runs the command
+//
+// When the command exits, the console vector is called with the exit
+// status on the read port and 0xff on the type port.
+// The command is split into words at spaces; it is not run by a shell.
+// Running a command kills any command that is already running.
+const (
+	procWrite byte = 0x01 // console output is written to the command's stdin
+	procRead  byte = 0x02 // the command's stdout is delivered as console input
+	procError byte = 0x04 // the command's stderr is delivered as console input
+	procKill  byte = 0x08 // only kill the running command, if any
+	procClose byte = 0x10 // only close the running command's stdin
+)
+
+// consoleExit is the type of the console input delivered when a command
+// exits, with the command's exit status as the input byte.
+const consoleExit byte = 0xff
+
+// process is a host command run by the Console device.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser // nil unless mode has procWrite
+}
+
+// allowed reports whether the named command may be run.
+func (c *Console) allowed(name string) bool {
+	for _, a := range c.allow {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs the command at the address on the addr port,
+// as specified by the mode port.
+func (c *Console) exec() {
+	mode := c.mem[0xe]
+	if mode&procClose != 0 {
+		if p := c.proc; p != nil && p.stdin != nil {
+			p.stdin.Close()
+		}
+		return
+	}
+	c.kill()
+	if mode&procKill != 0 {
+		return
+	}
+
+	addr := c.mem.short(0xc)
+	cmd, _, _ := bytes.Cut(c.main[addr:], []byte{0})
+	args := strings.Fields(string(cmd))
+	if len(args) == 0 {
+		log.Printf("console: empty command")
+		return
+	}
+	if !c.allowed(args[0]) {
+		log.Printf("console: command %q is not allowed", args[0])
+		return
+	}
+	p, err := c.start(args, mode)
+	if err != nil {
+		log.Printf("console: %v", err)
+		return
+	}
+	c.proc = p
+	c.mem[0x5] = 0x01
+	c.mem[0x6] = 0
+}
+
+// start starts the command given by args with the given mode.
+// Output of the command that is not delivered as console input
+// is written to the console's output and error writers.
+func (c *Console) start(args []string, mode byte) (*process, error) {
+	p := &process{cmd: exec.Command(args[0], args[1:]...)}
+	var (
+		outputs []io.Reader
+		err     error
+	)
+	if mode&procWrite != 0 {
+		if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if mode&procRead != 0 {
+		r, err := p.cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, r)
+	} else {
+		p.cmd.Stdout = c.out
+	}
+	if mode&procError != 0 {
+		r, err := p.cmd.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, r)
+	} else {
+		p.cmd.Stderr = c.err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c.listen()
+	input, ready := c.send, c.ready
+	go func() {
+		var wg sync.WaitGroup
+		for _, r := range outputs {
+			wg.Add(1)
+			go func(r io.Reader) {
+				defer wg.Done()
+				c.readProcess(p, r, input, ready)
+			}(r)
+		}
+		wg.Wait()
+		p.cmd.Wait()
+		code := byte(p.cmd.ProcessState.ExitCode())
+		c.put(consoleInput{code, consoleExit, p}, input, ready)
+	}()
+	return p, nil
+}
+
+// readProcess sends each byte read from r, the output of p, to input.
+func (c *Console) readProcess(p *process, r io.Reader, input chan<- consoleInput, ready chan<- bool) {
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if !c.put(consoleInput{b, consoleStd, p}, input, ready) {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// kill kills the running command, if any.
+// Any of its output not yet delivered is dropped.
+func (c *Console) kill() {
+	p := c.proc
+	if p == nil {
+		return
+	}
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	p.cmd.Process.Kill()
+	c.proc = nil
+	c.mem[0x5] = 0
+}
@@ -0,0 +1,253 @@
+package varvara
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// InputRecording is a sequence of input events delivered to a Varvara,
+// as written by a Runner with SetRecordInput.
+//
+// Recordings are stored as text, one event per line, each beginning with
+// the number of the frame on which the event was delivered: the number of
+// screen vectors that had been run before it. The frame number is followed
+// by the kind of event and its values:
+//
+//	<frame> c <player 1> <player 2> <player 3> <player 4> <key>
+//	<frame> m <x> <y> <buttons> <scroll x> <scroll y>
+//	<frame> k <byte> <type>
+//	<frame> end
+//
+// Controller buttons (c), mouse buttons (m), keys, and console input
+// bytes and their types (k) are given in hexadecimal as reported by
+// their devices, and
+// mouse positions and scroll amounts in decimal. The final line records
+// the number of frames that were run in total.
+//
+// Command-line arguments passed to the Console device are not recorded,
+// and must be given again when the recording is replayed.
+type InputRecording struct {
+	Events []InputEvent
+	Frames uint64 // the total number of frames
+}
+
+// InputEvent is an event in an InputRecording.
+type InputEvent struct {
+	Frame uint64
+	Kind  InputKind
+
+	Controller  ControllerState // if Kind is ControllerInput
+	Mouse       MouseState      // if Kind is MouseInput
+	Console     byte            // if Kind is ConsoleInput
+	ConsoleType byte            // if Kind is ConsoleInput
+}
+
+type InputKind byte
+
+const (
+	ControllerInput InputKind = 'c'
+	MouseInput      InputKind = 'm'
+	ConsoleInput    InputKind = 'k'
+)
+
+// ReadInputRecording reads an InputRecording from r.
+func ReadInputRecording(r io.Reader) (*InputRecording, error) {
+	var (
+		rec = &InputRecording{}
+		s   = bufio.NewScanner(r)
+		end = false
+	)
+	for n := 1; s.Scan(); n++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if end {
+			return nil, fmt.Errorf("line %d: event after end", n)
+		}
+		e, err := parseInputEvent(f)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if len(rec.Events) > 0 && e.Frame < rec.Events[len(rec.Events)-1].Frame {
+			return nil, fmt.Errorf("line %d: frame %d out of order", n, e.Frame)
+		}
+		if e.Kind == 0 {
+			end = true
+			rec.Frames = e.Frame
+			continue
+		}
+		rec.Events = append(rec.Events, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !end && len(rec.Events) > 0 {
+		// The recording was cut short,
+		// so stop after the frame of the last event.
+		rec.Frames = rec.Events[len(rec.Events)-1].Frame + 1
+	}
+	return rec, nil
+}
+
+// parseInputEvent parses the fields of a recorded event. The returned
+// event has Kind zero if the fields record the end of the recording.
+func parseInputEvent(f []string) (e InputEvent, err error) {
+	if len(f) < 2 {
+		return e, fmt.Errorf("short line")
+	}
+	e.Frame, err = strconv.ParseUint(f[0], 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("bad frame %q", f[0])
+	}
+	var (
+		args  = f[2:]
+		nargs int
+	)
+	switch f[1] {
+	case "end":
+		nargs = 0
+	case string(ControllerInput):
+		nargs = 5
+	case string(MouseInput):
+		nargs = 5
+	case string(ConsoleInput):
+		nargs = 2
+	default:
+		return e, fmt.Errorf("unknown event kind %q", f[1])
+	}
+	if len(args) != nargs {
+		return e, fmt.Errorf("%s event has %d values, want %d", f[1], len(args), nargs)
+	}
+	hex := func(s string) byte {
+		v, perr := strconv.ParseUint(s, 16, 8)
+		if perr != nil && err == nil {
+			err = fmt.Errorf("bad value %q", s)
+		}
+		return byte(v)
+	}
+	dec := func(s string) int16 {
+		v, perr := strconv.ParseInt(s, 10, 16)
+		if perr != nil && err == nil {
+			err = fmt.Errorf("bad value %q", s)
+		}
+		return int16(v)
+	}
+	switch f[1] {
+	case string(ControllerInput):
+		e.Kind = ControllerInput
+		c := &e.Controller
+		for p := 0; p < 4; p++ {
+			c.player(p).set(hex(args[p]))
+		}
+		c.Key = hex(args[4])
+	case string(MouseInput):
+		e.Kind = MouseInput
+		m := &e.Mouse
+		m.X, m.Y = dec(args[0]), dec(args[1])
+		b := hex(args[2])
+		for i := range m.Button {
+			m.Button[i] = b&(1<<i) != 0
+		}
+		m.ScrollX, m.ScrollY = dec(args[3]), dec(args[4])
+	case string(ConsoleInput):
+		e.Kind = ConsoleInput
+		e.Console = hex(args[0])
+		e.ConsoleType = hex(args[1])
+	}
+	return e, err
+}
+
+// inputRecorder writes the events delivered to a Varvara to w.
+type inputRecorder struct {
+	w     io.Writer
+	frame *uint64 // the current frame
+	err   error
+}
+
+func (r *inputRecorder) printf(format string, args ...any) {
+	if r.err != nil {
+		return
+	}
+	if _, r.err = fmt.Fprintf(r.w, "%d "+format+"\n", append([]any{*r.frame}, args...)...); r.err != nil {
+		log.Printf("recording input: %v", r.err)
+	}
+}
+
+func (r *inputRecorder) controller(s ControllerState) {
+	r.printf("c %.2x %.2x %.2x %.2x %.2x",
+		s.player(0).byte(), s.player(1).byte(), s.player(2).byte(), s.player(3).byte(), s.Key)
+}
+
+func (r *inputRecorder) mouse(s MouseState) {
+	var b byte
+	for i, down := range s.Button {
+		if down {
+			b |= 1 << i
+		}
+	}
+	r.printf("m %d %d %.2x %d %d", s.X, s.Y, b, s.ScrollX, s.ScrollY)
+}
+
+func (r *inputRecorder) console(in consoleInput) { r.printf("k %.2x %.2x", in.b, in.typ) }
+
+func (r *inputRecorder) end() { r.printf("end") }
+
+// recordInput makes v write the input events delivered to it to w.
+func (v *Varvara) recordInput(w io.Writer) {
+	r := &inputRecorder{w: w, frame: &v.frame}
+	v.cntrl.record = r.controller
+	v.mouse.record = r.mouse
+	v.con.record = r.console
+	v.recorder = r
+}
+
+// inputReplay delivers the events of an InputRecording to a Varvara.
+type inputReplay struct {
+	rec    *InputRecording
+	events []InputEvent // yet to be delivered
+}
+
+// replayInput makes v take its input from rec instead of its UI and
+// standard input, and run only as many frames as were recorded.
+func (v *Varvara) replayInput(rec *InputRecording) {
+	v.replay = &inputReplay{rec: rec, events: rec.Events}
+	v.con.in = nil
+	v.con.allow = nil // the output of any commands was recorded
+}
+
+// nextReplay delivers the next replayed event or runs the next frame,
+// and returns the vector to be run. It returns false once the recorded
+// number of frames has been run, or once no vector can run because no
+// events remain, there is no screen vector, and the console is not
+// waiting for input.
+func (v *Varvara) nextReplay() (vector uint16, ok bool) {
+	p := v.replay
+	if len(p.events) > 0 && p.events[0].Frame <= v.frame {
+		e := p.events[0]
+		p.events = p.events[1:]
+		switch e.Kind {
+		case ControllerInput:
+			v.cntrl.Set(&e.Controller)
+			return v.cntrl.next(), true
+		case MouseInput:
+			v.mouse.Set(&e.Mouse)
+			return v.mouse.next(), true
+		case ConsoleInput:
+			return v.con.deliver(consoleInput{e.Console, e.ConsoleType, nil}), true
+		}
+		return 0, true
+	}
+	if v.frame >= p.rec.Frames {
+		return 0, false
+	}
+	if len(p.events) == 0 && v.scr.Vector() == 0 && !v.con.listening() {
+		return 0, false
+	}
+	v.nextFrame()
+	return v.scr.Vector(), true
+}
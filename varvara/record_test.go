@@ -0,0 +1,138 @@
+package varvara
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nf/nux/uxn"
+)
+
+const testRecording = `0 c 09 00 01 00 61
+0 m -3 20 05 0 -1
+2 k 0a 01
+2 c 00 00 00 80 00
+4 end
+`
+
+func TestRecordInput(t *testing.T) {
+	v := newTestVarvara(t)
+	var buf bytes.Buffer
+	v.recordInput(&buf)
+
+	var c ControllerState
+	c.A, c.Start, c.Player[1].A, c.Key = true, true, true, 'a'
+	v.cntrl.Set(&c)
+	v.cntrl.next()
+	v.mouse.Set(&MouseState{X: -3, Y: 20, ScrollY: -1, Button: [3]bool{true, false, true}})
+	v.mouse.next()
+	v.frame = 2
+	v.con.deliver(consoleInput{'\n', consoleStd, nil})
+	v.cntrl.Set(&ControllerState{Player: [3]Buttons{2: {Right: true}}})
+	v.cntrl.next()
+	v.frame = 4
+	v.recorder.end()
+
+	if got := buf.String(); got != testRecording {
+		t.Errorf("recorded:\n%s\nwant:\n%s", got, testRecording)
+	}
+}
+
+func TestReadInputRecording(t *testing.T) {
+	rec, err := ReadInputRecording(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c ControllerState
+	c.A, c.Start, c.Player[1].A, c.Key = true, true, true, 'a'
+	want := &InputRecording{
+		Frames: 4,
+		Events: []InputEvent{
+			{Frame: 0, Kind: ControllerInput, Controller: c},
+			{Frame: 0, Kind: MouseInput, Mouse: MouseState{X: -3, Y: 20, ScrollY: -1, Button: [3]bool{true, false, true}}},
+			{Frame: 2, Kind: ConsoleInput, Console: '\n', ConsoleType: consoleStd},
+			{Frame: 2, Kind: ControllerInput, Controller: ControllerState{Player: [3]Buttons{2: {Right: true}}}},
+		},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("read %+v\nwant %+v", rec, want)
+	}
+
+	for _, bad := range []string{
+		"x c 00 00 00 00 00",
+		"0 c 00 00 00 00",
+		"0 c 00 00 00 00 100",
+		"0 m 1 2 03 0",
+		"0 q 00",
+		"0 k 0a",
+		"2 k 0a 01\n1 k 0a 01",
+		"2 end\n2 k 0a 01",
+	} {
+		if _, err := ReadInputRecording(strings.NewReader(bad)); err == nil {
+			t.Errorf("reading %q succeeded, want error", bad)
+		}
+	}
+}
+
+func TestReplayInput(t *testing.T) {
+	const (
+		cntrlVector = spriteAddr
+		conVector   = spriteAddr + 0x10
+		scrVector   = spriteAddr + 0x20
+	)
+	// The controller and console vectors write the key pressed or the
+	// byte read to the console, and the screen vector writes a dot.
+	handlers := make([]byte, 0x30)
+	copy(handlers[0x00:], cat([]byte{byte(uxn.LIT), 0x83, byte(uxn.DEI), byte(uxn.LIT), 0x18, byte(uxn.DEO)}, []byte{byte(uxn.BRK)}))
+	copy(handlers[0x10:], cat([]byte{byte(uxn.LIT), 0x12, byte(uxn.DEI), byte(uxn.LIT), 0x18, byte(uxn.DEO)}, []byte{byte(uxn.BRK)}))
+	copy(handlers[0x20:], cat(deo(0x18, '.'), []byte{byte(uxn.BRK)}))
+	rom := assemble(handlers,
+		deo2(0x80, cntrlVector),
+		deo2(0x10, conVector),
+		deo2(0x20, scrVector),
+	)
+
+	rec, err := ReadInputRecording(strings.NewReader("0 c 00 00 00 00 61\n3 k 62 01\n3 c 00 00 00 00 63\n5 end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	r := NewRunner(NoDisplay, false, nil)
+	r.SetOutput(&out)
+	r.SetReplayInput(rec)
+	r.Run(rom)
+	if got, want := out.String(), "a...bc.."; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestReplayInputIdle(t *testing.T) {
+	// Without a screen vector or any events to replay, nothing can run
+	// after the reset vector, so replay ends however many frames remain.
+	rom := assemble(nil, deo(0x18, 'x'))
+	var (
+		out  bytes.Buffer
+		r    = NewRunner(NoDisplay, false, nil)
+		done = make(chan int)
+	)
+	r.SetOutput(&out)
+	r.SetReplayInput(&InputRecording{Frames: math.MaxUint64})
+	go func() {
+		code, _ := r.Run(rom)
+		done <- code
+	}()
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Errorf("exit code = %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay did not end")
+	}
+	if got, want := out.String(), "x"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
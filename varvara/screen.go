@@ -11,7 +11,14 @@ type Screen struct {
 	sys  *System // r, g, b
 
 	fg, bg *image.RGBA
-	ops    int // total count of draw operations
+
+	// Regions of fg and bg changed since the last call to takeDirty.
+	fgDirty, bgDirty image.Rectangle
+
+	// The theme derived from the system colors in themeKey.
+	themeCache [4]color.RGBA
+	themeKey   [3]uint16
+	themeSet   bool
 }
 
 func (s *Screen) Vector() uint16 { return s.mem.short(0x0) }
@@ -53,14 +60,29 @@ func (s *Screen) Out(p, v byte) {
 	s.mem[p] = v
 
 	switch p {
-	default:
-		return
 	case 0xe:
 		s.drawPixel(drawOp(v))
 	case 0xf:
 		s.drawSprite(drawOp(v))
 	}
-	s.ops++
+}
+
+// takeDirty returns the regions of the foreground and background layers
+// that have been drawn to since the last call to takeDirty.
+func (s *Screen) takeDirty() (fg, bg image.Rectangle) {
+	fg, bg = s.fgDirty, s.bgDirty
+	s.fgDirty, s.bgDirty = image.Rectangle{}, image.Rectangle{}
+	return
+}
+
+// markDirty records that the given region of the layer selected by op
+// has been drawn to.
+func (s *Screen) markDirty(op drawOp, r image.Rectangle) {
+	if op.Foreground() {
+		s.fgDirty = s.fgDirty.Union(r.Intersect(s.fg.Bounds()))
+	} else {
+		s.bgDirty = s.bgDirty.Union(r.Intersect(s.bg.Bounds()))
+	}
 }
 
 var transparent = color.RGBA{0, 0, 0, 0}
@@ -76,18 +98,27 @@ func newImage(w, h uint16, c color.RGBA) *image.RGBA {
 	return m
 }
 
-func (s *Screen) myImageFor(op drawOp) (*image.RGBA, [4]color.RGBA) {
+func (s *Screen) theme() [4]color.RGBA {
 	r, g, b := s.sys.Red(), s.sys.Green(), s.sys.Blue()
-	theme := [4]color.RGBA{
-		{byte(r & 0xf000 >> 8), byte(g & 0xf000 >> 8), byte(b & 0xf000 >> 8), 0xff},
-		{byte(r & 0x0f00 >> 4), byte(g & 0x0f00 >> 4), byte(b & 0x0f00 >> 4), 0xff},
-		{byte(r & 0x00f0), byte(g & 0x00f0), byte(b & 0x00f0), 0xff},
-		{byte(r & 0x000f << 4), byte(g & 0x000f << 4), byte(b & 0x000f << 4), 0xff},
+	if key := [3]uint16{r, g, b}; key != s.themeKey || !s.themeSet {
+		s.themeCache = [4]color.RGBA{
+			{byte(r & 0xf000 >> 8), byte(g & 0xf000 >> 8), byte(b & 0xf000 >> 8), 0xff},
+			{byte(r & 0x0f00 >> 4), byte(g & 0x0f00 >> 4), byte(b & 0x0f00 >> 4), 0xff},
+			{byte(r & 0x00f0), byte(g & 0x00f0), byte(b & 0x00f0), 0xff},
+			{byte(r & 0x000f << 4), byte(g & 0x000f << 4), byte(b & 0x000f << 4), 0xff},
+		}
+		s.themeKey, s.themeSet = key, true
 	}
+	return s.themeCache
+}
+
+func (s *Screen) myImageFor(op drawOp) (*image.RGBA, [4]color.RGBA) {
+	theme := s.theme()
 	size := image.Point{int(s.Width()), int(s.Height())}
 	if s.fg == nil || s.fg.Bounds().Size() != size {
 		s.fg = newImage(s.Width(), s.Height(), transparent)
 		s.bg = newImage(s.Width(), s.Height(), theme[0])
+		s.fgDirty, s.bgDirty = s.fg.Bounds(), s.bg.Bounds()
 	}
 	if op.Foreground() {
 		return s.fg, theme
@@ -96,6 +127,15 @@ func (s *Screen) myImageFor(op drawOp) (*image.RGBA, [4]color.RGBA) {
 	}
 }
 
+// image returns the screen as it appears,
+// with the foreground layer drawn over the background.
+func (s *Screen) image() *image.RGBA {
+	s.myImageFor(0) // Allocate the layers if nothing has been drawn.
+	m := image.NewRGBA(s.bg.Bounds())
+	composite(m, s.fg, s.bg, m.Bounds())
+	return m
+}
+
 func (s *Screen) drawPixel(op drawOp) {
 	m, theme := s.myImageFor(op)
 	c := transparent
@@ -116,8 +156,17 @@ func (s *Screen) drawPixel(op drawOp) {
 				m.SetRGBA(x, y, c)
 			}
 		}
+		r := image.Rect(int(s.X()), int(s.Y()), size.X, size.Y)
+		if op.FlipX() {
+			r.Min.X, r.Max.X = 0, int(s.X())+1
+		}
+		if op.FlipY() {
+			r.Min.Y, r.Max.Y = 0, int(s.Y())+1
+		}
+		s.markDirty(op, r)
 	} else {
 		m.SetRGBA(int(s.X()), int(s.Y()), c)
+		s.markDirty(op, image.Rect(int(s.X()), int(s.Y()), int(s.X())+1, int(s.Y())+1))
 	}
 	if s.Auto().X() {
 		s.setX(s.X() + 1)
@@ -132,73 +181,141 @@ func (s *Screen) drawSprite(op drawOp) {
 		m, theme = s.myImageFor(op)
 		auto     = s.Auto()
 		addr     = s.Addr()
-		sx, sy   = int(s.X()), int(s.Y()) // sprite top-left
-		dx, dy   = 1, 1
-		// drawZero reports whether this blending mode should draw
-		// color zero; if false, pixels of color zero are not set.
-		drawZero = op.Blend() == 0 || op.Blend()%5 != 0
-		sprite   = s.main[addr:]
+		x, y     = int(s.X()), int(s.Y())
+		// Repeats with auto x are drawn below (or, if flipped, above)
+		// one another, and repeats with auto y are drawn to the right
+		// (or, if flipped, to the left).
+		dx, dy = 0, 0
+		pal    = newSpritePalette(op, theme)
+		size   = uint16(0x08)
 	)
-	if !op.FlipX() {
-		dx = -1
-		sx += 7
+	if auto.Y() {
+		dx = 8
+		if op.FlipX() {
+			dx = -8
+		}
 	}
-	if op.FlipY() {
-		dy = -1
-		sy += 7
+	if auto.X() {
+		dy = 8
+		if op.FlipY() {
+			dy = -8
+		}
+	}
+	if op.TwoBit() {
+		size = 0x10
 	}
 	for i := auto.Count(); i >= 0; i-- {
-		var x, y = sx, sy
-		for j := 0; j < 8; j++ {
-			pxA, pxB := sprite[j], sprite[j+8]
-			for i := 0; i < 8; i++ {
-				px := pxA & 1
-				pxA >>= 1
-				if op.TwoBit() {
-					px |= pxB & 1 << 1
-					pxB >>= 1
-				}
-				px = drawBlendingModes[px][op.Blend()]
-				if drawZero || px > 0 {
-					c := transparent
-					if !op.Foreground() || px > 0 {
-						c = theme[px]
-					}
-					m.Set(x, y, c)
-				}
-				x += dx
-			}
-			x += -dx * 8
-			y += dy
-		}
-		if auto.X() {
-			sy += 8 * dy
-		}
-		if auto.Y() {
-			sx += 8 * -dx
-		}
+		blitSprite(m, s.main[int(addr):int(addr)+int(size)], x, y, op, &pal)
+		s.markDirty(op, image.Rect(x, y, x+8, y+8))
+		x += dx
+		y += dy
 		if auto.Addr() {
-			if op.TwoBit() {
-				addr += 0x10
-			} else {
-				addr += 0x08
-			}
-			sprite = s.main[addr:]
+			addr += size
 		}
 	}
 	if auto.X() {
-		s.setX(s.X() + 8*int16(-dx))
+		if op.FlipX() {
+			s.setX(s.X() - 8)
+		} else {
+			s.setX(s.X() + 8)
+		}
 	}
 	if auto.Y() {
-		s.setY(s.Y() + 8*int16(dy))
+		if op.FlipY() {
+			s.setY(s.Y() - 8)
+		} else {
+			s.setY(s.Y() + 8)
+		}
 	}
 	if auto.Addr() {
 		s.setAddr(addr)
 	}
 }
 
+// spritePalette holds the pixel values that a sprite draw operation
+// writes for each of the four sprite colors.
+type spritePalette struct {
+	draw [4]bool    // whether to draw pixels of this color at all
+	pix  [4][4]byte // RGBA bytes to write
+}
+
+func newSpritePalette(op drawOp, theme [4]color.RGBA) (p spritePalette) {
+	for px, c := range spriteBlend[op.Blend()] {
+		if c < 0 {
+			continue
+		}
+		rgba := transparent
+		if !op.Foreground() || c > 0 {
+			rgba = theme[c]
+		}
+		p.draw[px] = true
+		p.pix[px] = [4]byte{rgba.R, rgba.G, rgba.B, rgba.A}
+	}
+	return
+}
+
+// blitSprite draws the 8x8 sprite with its top-left corner at x, y.
+// The sprite is 8 bytes long if op is a 1bpp operation and 16 bytes long
+// if it is a 2bpp operation. Pixels that fall outside m are not drawn.
+func blitSprite(m *image.RGBA, sprite []byte, x, y int, op drawOp, p *spritePalette) {
+	var (
+		b      = m.Bounds()
+		twoBit = op.TwoBit()
+		flipX  = op.FlipX()
+		flipY  = op.FlipY()
+	)
+	for row := 0; row < 8; row++ {
+		py := y + row
+		if flipY {
+			py = y + 7 - row
+		}
+		if py < b.Min.Y || py >= b.Max.Y {
+			continue
+		}
+		lo, hi := sprite[row], byte(0)
+		if twoBit {
+			hi = sprite[row+8]
+		}
+		for col := 0; col < 8; col++ {
+			// The most significant bit is the left-most pixel.
+			shift := 7 - col
+			px := lo>>shift&1 | hi>>shift&1<<1
+			if !p.draw[px] {
+				continue
+			}
+			pxX := x + col
+			if flipX {
+				pxX = x + 7 - col
+			}
+			if pxX < b.Min.X || pxX >= b.Max.X {
+				continue
+			}
+			i := m.PixOffset(pxX, py)
+			copy(m.Pix[i:i+4], p.pix[px][:])
+		}
+	}
+}
+
 var drawBlendingModes = [4][16]byte{
 	{0, 0, 0, 0, 1, 0, 1, 1, 2, 2, 0, 2, 3, 3, 3, 0},
 	{0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3},
 	{1, 2, 3, 1, 1, 2, 3, 1, 1, 2, 3, 1, 1, 2, 3, 1},
 	{2, 3, 1, 2, 2, 3, 1, 2, 2, 3, 1, 2, 2, 3, 1, 2}}
+
+// spriteBlend holds, for each blending mode and each sprite pixel value,
+// the theme color to draw or -1 if the pixel should not be drawn.
+// Blending modes 5, 10, and 15 don't draw pixels of value zero.
+var spriteBlend = func() (t [16][4]int8) {
+	for blend := range t {
+		drawZero := blend == 0 || blend%5 != 0
+		for px := range t[blend] {
+			c := drawBlendingModes[px][blend]
+			if px == 0 && !drawZero {
+				t[blend][px] = -1
+			} else {
+				t[blend][px] = int8(c)
+			}
+		}
+	}
+	return
+}()
@@ -0,0 +1,92 @@
+package varvara
+
+import (
+	"image"
+	"testing"
+)
+
+func newTestScreen(w, h uint16) *Screen {
+	s := &Screen{
+		main: make([]byte, 0x10000),
+		sys:  &System{},
+	}
+	s.setWidth(w)
+	s.setHeight(h)
+	return s
+}
+
+func TestScreenDirty(t *testing.T) {
+	s := newTestScreen(0x40, 0x30)
+	bounds := image.Rect(0, 0, 0x40, 0x30)
+
+	// The first draw allocates both layers, which marks them dirty.
+	s.setX(4)
+	s.setY(5)
+	s.Out(0xe, 0x01)
+	if fg, bg := s.takeDirty(); fg != bounds || bg != bounds {
+		t.Fatalf("after first draw, dirty = %v, %v; want %v, %v", fg, bg, bounds, bounds)
+	}
+	if fg, bg := s.takeDirty(); !fg.Empty() || !bg.Empty() {
+		t.Fatalf("after takeDirty, dirty = %v, %v; want empty", fg, bg)
+	}
+
+	for _, c := range []struct {
+		name   string
+		x, y   int16
+		port   byte
+		op     byte
+		fg, bg image.Rectangle
+	}{
+		{"pixel bg", 4, 5, 0xe, 0x01, image.Rectangle{}, image.Rect(4, 5, 5, 6)},
+		{"pixel fg", 4, 5, 0xe, 0x41, image.Rect(4, 5, 5, 6), image.Rectangle{}},
+		{"pixel offscreen", 0x50, 5, 0xe, 0x01, image.Rectangle{}, image.Rectangle{}},
+		{"fill", 4, 5, 0xe, 0x81, image.Rectangle{}, image.Rect(4, 5, 0x40, 0x30)},
+		{"fill flipped", 4, 5, 0xe, 0xb1, image.Rectangle{}, image.Rect(0, 0, 5, 6)},
+		{"sprite", 4, 5, 0xf, 0x01, image.Rectangle{}, image.Rect(4, 5, 12, 13)},
+		{"sprite flipped", 4, 5, 0xf, 0x71, image.Rect(4, 5, 12, 13), image.Rectangle{}},
+		{"sprite clipped", 0x3c, 0x2c, 0xf, 0x01, image.Rectangle{}, image.Rect(0x3c, 0x2c, 0x40, 0x30)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s.setX(c.x)
+			s.setY(c.y)
+			s.Out(c.port, c.op)
+			if fg, bg := s.takeDirty(); fg != c.fg || bg != c.bg {
+				t.Errorf("dirty = %v, %v; want %v, %v", fg, bg, c.fg, c.bg)
+			}
+		})
+	}
+}
+
+// benchmarkFrames simulates a large screen on which one sprite
+// is drawn per frame, after which the layers are copied to dst.
+func benchmarkFrames(b *testing.B, copyFrame func(s *Screen, fg, bg *image.RGBA)) {
+	s := newTestScreen(640, 480)
+	s.Out(0xf, 0x01)
+	var (
+		r      = image.Rect(0, 0, 640, 480)
+		fg, bg = image.NewRGBA(r), image.NewRGBA(r)
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.setX(int16(i % 640))
+		s.setY(int16(i % 480))
+		s.Out(0xf, 0x41)
+		copyFrame(s, fg, bg)
+	}
+}
+
+func BenchmarkFrameFullCopy(b *testing.B) {
+	benchmarkFrames(b, func(s *Screen, fg, bg *image.RGBA) {
+		s.takeDirty()
+		copy(fg.Pix, s.fg.Pix)
+		copy(bg.Pix, s.bg.Pix)
+	})
+}
+
+func BenchmarkFrameDirtyCopy(b *testing.B) {
+	benchmarkFrames(b, func(s *Screen, fg, bg *image.RGBA) {
+		fgr, bgr := s.takeDirty()
+		copyRect(fg, s.fg, fgr)
+		copyRect(bg, s.bg, bgr)
+	})
+}
@@ -0,0 +1,123 @@
+package varvara
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testSprite is an asymmetric 2bpp sprite that uses all four colors,
+// so that flips and blending modes are distinguishable.
+var testSprite = []byte{
+	0xf0, 0x88, 0x84, 0x82, 0x81, 0x41, 0x21, 0x1f,
+	0x00, 0x7c, 0x42, 0x4a, 0x42, 0x3c, 0x00, 0xff,
+}
+
+// TestSprite draws testSprite with every blending mode and flip
+// combination, and with each auto-repeat mode, in 1bpp and 2bpp,
+// on both layers, and compares the results to golden images.
+//
+// The golden images were not made with -update. They were rendered by
+// drawTestSprites against the original per-pixel drawSprite, whose
+// flip and auto-repeat handling follows the reference emulator. Any
+// change to them should be checked against uxn11 or that version.
+func TestSprite(t *testing.T) {
+	for _, twoBit := range []bool{false, true} {
+		for _, fg := range []bool{false, true} {
+			var (
+				bpp   = map[bool]string{false: "1bpp", true: "2bpp"}[twoBit]
+				layer = map[bool]string{false: "bg", true: "fg"}[fg]
+				name  = fmt.Sprintf("sprite-%s-%s", bpp, layer)
+			)
+			t.Run(name, func(t *testing.T) {
+				s := drawTestSprites(twoBit, fg)
+				m := s.bg
+				if fg {
+					m = s.fg
+				}
+				checkGolden(t, name, m)
+			})
+		}
+	}
+}
+
+func drawTestSprites(twoBit, fg bool) *Screen {
+	s := newTestScreen(16*10+2, 4*10+2*26+2)
+	s.sys.mem.setShort(0x8, 0x0f5a)
+	s.sys.mem.setShort(0xa, 0x05fa)
+	s.sys.mem.setShort(0xc, 0x0a5f)
+	copy(s.main[0x200:], testSprite)
+	copy(s.main[0x210:], testSprite[8:])
+	copy(s.main[0x220:], testSprite)
+
+	var base byte
+	if twoBit {
+		base |= 0x80
+	}
+	if fg {
+		base |= 0x40
+	}
+
+	// Every blending mode (columns) with every flip (rows).
+	for flip := 0; flip < 4; flip++ {
+		for blend := 0; blend < 16; blend++ {
+			s.setAddr(0x200)
+			s.setX(int16(2 + blend*10))
+			s.setY(int16(2 + flip*10))
+			s.Out(0xf, base|byte(flip)<<4|byte(blend))
+		}
+	}
+
+	// Auto-repeat with each combination of auto x, y, and addr,
+	// with and without flips.
+	// Flipped sprites repeat up and to the left, so start those at the
+	// bottom right of their cell.
+	for i, auto := range []byte{0x01, 0x02, 0x03, 0x05, 0x06, 0x07} {
+		for j, flip := range []byte{0x00, 0x30} {
+			s.mem[0x6] = 0x20 | auto
+			s.setAddr(0x200)
+			s.setX(int16(2 + i*26 + 16*j))
+			s.setY(int16(42 + j*26 + 16*j))
+			s.Out(0xf, base|flip|0x01)
+		}
+	}
+	s.mem[0x6] = 0
+	return s
+}
+
+func BenchmarkDrawSprite(b *testing.B) {
+	s := newTestScreen(0x100, 0x100)
+	copy(s.main[0x200:], testSprite)
+	s.setAddr(0x200)
+	s.Out(0xf, 0x81)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.setX(int16(i % 0xf8))
+		s.setY(int16(i % 0xf0))
+		s.Out(0xf, 0xc1|byte(i)&0x3f)
+	}
+}
+
+// TestSpriteHighAddress draws sprites that end at the top of memory.
+func TestSpriteHighAddress(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		addr uint16
+		op   byte
+	}{
+		{"1bpp", 0xfff8, 0x01},
+		{"2bpp", 0xfff0, 0x81},
+	} {
+		draw := func(addr uint16) *Screen {
+			s := newTestScreen(8, 8)
+			copy(s.main[addr:], testSprite)
+			s.setAddr(addr)
+			s.Out(0xf, c.op)
+			return s
+		}
+		got, want := draw(c.addr), draw(0x200)
+		if !bytes.Equal(got.bg.Pix, want.bg.Pix) {
+			t.Errorf("%s sprite at %.4x differs from the same sprite at 0200", c.name, c.addr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package varvara
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// sharedInput reads console input from a single reader on behalf of the
+// successive Varvara machines of a Runner, so that machines replaced by
+// a reset or swap do not leave goroutines behind competing for it.
+type sharedInput struct {
+	r    io.Reader
+	once sync.Once
+	data chan []byte // chunks read from r; closed at the end of the input
+
+	mu      sync.Mutex
+	pending []byte // read from r but not yet consumed
+	err     error  // the error that ended the input, once data is closed
+}
+
+func newSharedInput(r io.Reader) *sharedInput {
+	return &sharedInput{r: r, data: make(chan []byte)}
+}
+
+func (s *sharedInput) pump() {
+	for {
+		buf := make([]byte, 256)
+		n, err := s.r.Read(buf)
+		if n > 0 {
+			s.data <- buf[:n]
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			close(s.data)
+			return
+		}
+	}
+}
+
+// reader returns a reader for the input that stops reading
+// once done is closed.
+func (s *sharedInput) reader(done <-chan struct{}) *inputReader {
+	return &inputReader{s: s, done: done}
+}
+
+// errConsoleClosed is returned by reads from a closed Console.
+var errConsoleClosed = errors.New("console closed")
+
+// inputReader reads from a sharedInput on behalf of one Varvara machine.
+type inputReader struct {
+	s    *sharedInput
+	done <-chan struct{}
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.once.Do(func() { go s.pump() })
+
+	s.mu.Lock()
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+		return n, nil
+	}
+	s.mu.Unlock()
+
+	select {
+	case b, ok := <-s.data:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !ok {
+			return 0, s.err
+		}
+		n := copy(p, b)
+		s.pending = append(s.pending, b[n:]...)
+		return n, nil
+	case <-r.done:
+		return 0, errConsoleClosed
+	}
+}
+
+// unread returns input that was read but not delivered,
+// so that it is read again by the next machine.
+func (r *inputReader) unread(p []byte) {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(append([]byte(nil), p...), s.pending...)
+}
@@ -0,0 +1,526 @@
+package varvara
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/mobile/event/key"
+	"golang.org/x/term"
+)
+
+// Terminal is a UI that draws the Varvara screen in a terminal
+// using Unicode half-block or braille characters in true color.
+type Terminal struct {
+	frameSync
+
+	v    *Varvara // only touch this in the update method, or to queue input events!
+	newV *Varvara // set after Swap, unset once swap happens
+
+	debug   Debugger
+	braille bool
+	keys    KeyMap
+	text    TextInput
+
+	ctrl  ControllerState
+	mouse MouseState
+
+	// Terminals report key presses but not releases, so controller
+	// buttons are held until the time recorded here.
+	held map[*bool]time.Time
+
+	// Screen
+	newScreen func() (tcell.Screen, error)
+	scr       tcell.Screen
+	size      image.Point // of the Varvara screen
+	pix       *image.RGBA // composited fg and bg
+	dirty     bool        // pix must be redrawn to the terminal
+	view      image.Rectangle
+	scale     float64 // from Varvara pixels to view dots
+	dotsX     int     // dots per cell
+	dotsY     int
+	colors    map[color.RGBA]tcell.Color
+}
+
+// buttonHoldTime is how long a controller button stays pressed after its
+// key was last reported. It should exceed the terminal's key repeat interval.
+const buttonHoldTime = 150 * time.Millisecond
+
+// NewTerminal returns a Terminal UI for the given Varvara.
+// It draws with braille characters if braille is true,
+// and half-block characters otherwise.
+// Keys are mapped to the Controller device using k,
+// or DefaultKeyMap if k is nil, and typed text is delivered
+// as specified by text.
+func NewTerminal(v *Varvara, d Debugger, braille bool, k KeyMap, text TextInput) *Terminal {
+	if k == nil {
+		k = DefaultKeyMap()
+	}
+	t := &Terminal{
+		frameSync: newFrameSync(),
+		v:         v,
+		debug:     d,
+		braille:   braille,
+		keys:      k,
+		text:      text,
+		held:      map[*bool]time.Time{},
+		colors:    map[color.RGBA]tcell.Color{},
+		dotsX:     1,
+		dotsY:     2,
+		newScreen: tcell.NewScreen,
+	}
+	if braille {
+		t.dotsX, t.dotsY = 2, 4
+	}
+	return t
+}
+
+// Swap replaces the Varvara attached to Terminal with the given one.
+// This operation takes effect on the next update event.
+// Swap may only be called when a Terminal update is not in progress.
+func (t *Terminal) Swap(v *Varvara) { t.newV = v }
+
+func (t *Terminal) Run(exit <-chan bool) error {
+	defer close(t.updateDone)
+
+	s, err := t.newScreen()
+	if err != nil {
+		return err
+	}
+	if err := s.Init(); err != nil {
+		return err
+	}
+	defer s.Fini()
+	s.EnableMouse()
+	s.HideCursor()
+	t.scr = s
+
+	var (
+		events = make(chan tcell.Event)
+		quit   = make(chan struct{})
+		tick   = time.NewTicker(time.Second / 60)
+	)
+	defer tick.Stop()
+	defer close(quit)
+	go s.ChannelEvents(events, quit)
+
+	for {
+		select {
+		case <-exit:
+			return nil
+		case e := <-events:
+			if err := t.handle(e); err == errCloseGUI {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		case <-tick.C:
+			select {
+			case <-t.doUpdate:
+				t.update()
+				t.updateDone <- true
+			default:
+				// uxn cpu is busy
+			}
+			t.paint()
+		}
+	}
+}
+
+func (t *Terminal) handle(e tcell.Event) error {
+	switch e := e.(type) {
+	case *tcell.EventResize:
+		t.scr.Sync()
+		t.updateView()
+	case *tcell.EventKey:
+		return t.handleKey(e)
+	case *tcell.EventMouse:
+		t.handleMouse(e)
+	case *tcell.EventError:
+		return e
+	}
+	return nil
+}
+
+// update synchronizes state between the terminal and Varvara.
+// It must only be called when the Varvara CPU is not executing.
+func (t *Terminal) update() {
+	resetScreen := false
+	if t.newV != nil {
+		t.v = t.newV
+		t.newV = nil
+		t.ctrl = ControllerState{}
+		t.mouse = MouseState{}
+		t.held = map[*bool]time.Time{}
+		resetScreen = true
+	}
+
+	released := false
+	now := time.Now()
+	for b, until := range t.held {
+		if now.After(until) {
+			*b = false
+			delete(t.held, b)
+			released = true
+		}
+	}
+	if released {
+		t.v.cntrl.Set(&t.ctrl)
+	}
+
+	// Screen
+	size := image.Point{int(t.v.scr.Width()), int(t.v.scr.Height())}
+	if size.X == 0 || size.Y == 0 {
+		size = image.Point{0x100, 0x100}
+	}
+	full := false
+	if resetScreen || t.pix == nil || t.size != size {
+		t.size = size
+		t.pix = image.NewRGBA(image.Rectangle{Max: size})
+		full = true
+		t.updateView()
+	}
+	fgr, bgr := t.v.scr.takeDirty()
+	r := fgr.Union(bgr)
+	if full {
+		r = t.pix.Bounds()
+	}
+	fg, bg := t.v.scr.fg, t.v.scr.bg
+	if !r.Empty() && fg != nil && bg != nil && fg.Bounds().Size() == size {
+		composite(t.pix, fg, bg, r)
+		t.dirty = true
+	}
+}
+
+// composite draws the pixels within r of fg over those of bg into dst.
+// All three images must be the same size.
+func composite(dst, fg, bg *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for i := dst.PixOffset(r.Min.X, y); i < dst.PixOffset(r.Max.X, y); i += 4 {
+			src := bg.Pix
+			if fg.Pix[i+3] != 0 {
+				src = fg.Pix
+			}
+			copy(dst.Pix[i:i+4], src[i:i+4])
+		}
+	}
+}
+
+// updateView computes the largest area of the terminal that the Varvara
+// screen can be drawn to while preserving its aspect ratio.
+func (t *Terminal) updateView() {
+	if t.scr == nil || t.pix == nil {
+		return
+	}
+	var (
+		cols, rows = t.scr.Size()
+		wx, wy     = float64(cols * t.dotsX), float64(rows * t.dotsY)
+		sx, sy     = float64(t.size.X), float64(t.size.Y)
+	)
+	t.scale = wx / sx
+	if s := wy / sy; s < t.scale {
+		t.scale = s
+	}
+	var (
+		w = int(sx * t.scale / float64(t.dotsX))
+		h = int(sy * t.scale / float64(t.dotsY))
+		x = (cols - w) / 2
+		y = (rows - h) / 2
+	)
+	t.view = image.Rect(x, y, x+w, y+h)
+	t.dirty = true
+}
+
+// paint draws the composited Varvara screen to the terminal.
+func (t *Terminal) paint() {
+	if !t.dirty || t.pix == nil {
+		return
+	}
+	t.dirty = false
+	t.scr.Clear()
+	for cy := t.view.Min.Y; cy < t.view.Max.Y; cy++ {
+		for cx := t.view.Min.X; cx < t.view.Max.X; cx++ {
+			if t.braille {
+				t.paintBraille(cx, cy)
+			} else {
+				t.paintHalfBlock(cx, cy)
+			}
+		}
+	}
+	t.scr.Show()
+}
+
+func (t *Terminal) paintHalfBlock(cx, cy int) {
+	var (
+		top    = t.dot(cx, cy, 0, 0)
+		bottom = t.dot(cx, cy, 0, 1)
+		style  = tcell.StyleDefault.
+			Foreground(t.color(top)).
+			Background(t.color(bottom))
+	)
+	t.scr.SetContent(cx, cy, '▀', nil, style)
+}
+
+// brailleDots maps dot positions within a braille character cell
+// to the bits of its Unicode code point.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func (t *Terminal) paintBraille(cx, cy int) {
+	// A braille cell can show only two colors, so draw the most common
+	// color as the background and the next most common as the dots.
+	var (
+		dots   [4][2]color.RGBA
+		counts = map[color.RGBA]int{}
+	)
+	for y := range dots {
+		for x := range dots[y] {
+			c := t.dot(cx, cy, x, y)
+			dots[y][x] = c
+			counts[c]++
+		}
+	}
+	var bg, fg color.RGBA
+	for c, n := range counts {
+		if n > counts[bg] || n == counts[bg] && less(c, bg) {
+			bg = c
+		}
+	}
+	delete(counts, bg)
+	for c, n := range counts {
+		if n > counts[fg] || n == counts[fg] && less(c, fg) {
+			fg = c
+		}
+	}
+	r := rune(0x2800)
+	for y := range dots {
+		for x, c := range dots[y] {
+			if c != bg {
+				r |= brailleDots[y][x]
+			}
+		}
+	}
+	style := tcell.StyleDefault.
+		Foreground(t.color(fg)).
+		Background(t.color(bg))
+	t.scr.SetContent(cx, cy, r, nil, style)
+}
+
+// less provides a stable ordering of colors so that ties are broken
+// consistently from frame to frame.
+func less(a, b color.RGBA) bool {
+	return uint32(a.R)<<16|uint32(a.G)<<8|uint32(a.B) <
+		uint32(b.R)<<16|uint32(b.G)<<8|uint32(b.B)
+}
+
+// dot returns the color of the Varvara pixel under the given dot
+// of the given terminal cell.
+func (t *Terminal) dot(cx, cy, dx, dy int) color.RGBA {
+	x := float64((cx-t.view.Min.X)*t.dotsX+dx) / t.scale
+	y := float64((cy-t.view.Min.Y)*t.dotsY+dy) / t.scale
+	return t.pix.RGBAAt(int(x), int(y))
+}
+
+func (t *Terminal) color(c color.RGBA) tcell.Color {
+	tc, ok := t.colors[c]
+	if !ok {
+		tc = tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+		t.colors[c] = tc
+	}
+	return tc
+}
+
+func (t *Terminal) handleKey(e *tcell.EventKey) error {
+	switch e.Key() {
+	case tcell.KeyCtrlC:
+		return errCloseGUI
+	case tcell.KeyF4:
+		t.debug.Debug("reset", 0)
+		return nil
+	case tcell.KeyF5:
+		t.debug.Debug("cont", 0)
+		return nil
+	case tcell.KeyF6:
+		t.debug.Debug("step", 0)
+		return nil
+	case tcell.KeyF7:
+		t.debug.Debug("halt", 0)
+		return nil
+	}
+	var (
+		s    = &t.ctrl
+		mods = e.Modifiers()
+	)
+	// Modifier keys are only reported alongside other keys.
+	for _, m := range []struct {
+		mod  tcell.ModMask
+		code key.Code
+	}{
+		{tcell.ModCtrl, key.CodeLeftControl},
+		{tcell.ModAlt, key.CodeLeftAlt},
+		{tcell.ModShift, key.CodeLeftShift},
+	} {
+		if mods&m.mod != 0 {
+			if b := t.keys[m.code]; b.Button != 0 {
+				t.hold(s.player(b.Player).button(b.Button))
+			}
+		}
+	}
+	var (
+		bind = t.keys[terminalKeyCode(e)]
+		r    rune // typed non-ASCII rune
+	)
+	if bind.Button != 0 {
+		t.hold(s.player(bind.Player).button(bind.Button))
+	}
+	switch k := e.Key(); {
+	case bind.Key != 0:
+		s.Key = bind.Key
+	case k == tcell.KeyRune:
+		if c := e.Rune(); 0 <= c && c < 0x80 {
+			s.Key = byte(c)
+		} else {
+			r = c
+		}
+	case 0 < k && k < 0x80:
+		// An ASCII control character.
+		b := byte(k)
+		if mods&tcell.ModCtrl != 0 && 'A'-0x40 <= b && b <= 'Z'-0x40 {
+			b += 0x60
+		}
+		s.Key = b
+	}
+	sendKey(t.v, s, t.text, r)
+	return nil
+}
+
+// terminalKeyCode returns the key code corresponding to the key
+// reported by e, or zero if there is none.
+func terminalKeyCode(e *tcell.EventKey) key.Code {
+	switch e.Key() {
+	case tcell.KeyHome:
+		return key.CodeHome
+	case tcell.KeyUp:
+		return key.CodeUpArrow
+	case tcell.KeyDown:
+		return key.CodeDownArrow
+	case tcell.KeyLeft:
+		return key.CodeLeftArrow
+	case tcell.KeyRight:
+		return key.CodeRightArrow
+	case tcell.KeyEnter:
+		return key.CodeReturnEnter
+	case tcell.KeyTab:
+		return key.CodeTab
+	case tcell.KeyEscape:
+		return key.CodeEscape
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		return key.CodeDeleteBackspace
+	case tcell.KeyDelete:
+		return key.CodeDeleteForward
+	case tcell.KeyRune:
+		switch r := e.Rune(); {
+		case 'a' <= r && r <= 'z':
+			return key.CodeA + key.Code(r-'a')
+		case 'A' <= r && r <= 'Z':
+			return key.CodeA + key.Code(r-'A')
+		case '1' <= r && r <= '9':
+			return key.Code1 + key.Code(r-'1')
+		case r == '0':
+			return key.Code0
+		case r == ' ':
+			return key.CodeSpacebar
+		}
+	}
+	return 0
+}
+
+func (t *Terminal) hold(b *bool) {
+	*b = true
+	t.held[b] = time.Now().Add(buttonHoldTime)
+}
+
+func (t *Terminal) handleMouse(e *tcell.EventMouse) {
+	if t.pix == nil || t.scale == 0 {
+		// Screen not initialized; can't compute mouse x/y.
+		return
+	}
+	var (
+		m      = &t.mouse
+		cx, cy = e.Position()
+		bs     = e.Buttons()
+	)
+	m.X = clampInt16((float64((cx-t.view.Min.X)*t.dotsX) + float64(t.dotsX)/2) / t.scale)
+	m.Y = clampInt16((float64((cy-t.view.Min.Y)*t.dotsY) + float64(t.dotsY)/2) / t.scale)
+	m.Button[0] = bs&tcell.Button1 != 0
+	m.Button[1] = bs&tcell.Button3 != 0
+	m.Button[2] = bs&tcell.Button2 != 0
+	s := *m
+	switch {
+	case bs&tcell.WheelUp != 0:
+		s.ScrollY = -1
+	case bs&tcell.WheelDown != 0:
+		s.ScrollY = 1
+	case bs&tcell.WheelLeft != 0:
+		s.ScrollX = -1
+	case bs&tcell.WheelRight != 0:
+		s.ScrollX = 1
+	}
+	t.v.mouse.Set(&s)
+}
+
+// detachTerminal stops the Console device of r's machines from using
+// the terminal while a Terminal UI draws to it. Standard input is not
+// read if it is the terminal, and output to the terminal is held until
+// the returned function is called, once the UI has finished.
+func (r *Runner) detachTerminal() (flush func()) {
+	isTerminal := func(f *os.File) bool { return term.IsTerminal(int(f.Fd())) }
+	if r.stdin == nil && isTerminal(os.Stdin) {
+		r.noStdin = true
+	}
+	var held []*heldOutput
+	hold := func(w *io.Writer, f *os.File) {
+		if *w == io.Writer(f) && isTerminal(f) {
+			h := &heldOutput{w: f}
+			held = append(held, h)
+			*w = h
+		}
+	}
+	hold(&r.stdout, os.Stdout)
+	hold(&r.stderr, os.Stderr)
+	return func() {
+		for _, h := range held {
+			h.flush()
+		}
+	}
+}
+
+// heldOutput holds output for its writer until it is flushed.
+type heldOutput struct {
+	w  io.Writer
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (h *heldOutput) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.b.Write(p)
+}
+
+func (h *heldOutput) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.w.Write(h.b.Bytes())
+	h.b.Reset()
+}
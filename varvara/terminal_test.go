@@ -0,0 +1,324 @@
+package varvara
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/mobile/event/key"
+)
+
+// debugOps is a Debugger that records the commands it is given.
+type debugOps []string
+
+func (d *debugOps) Debug(cmd string, addr uint16) { *d = append(*d, cmd) }
+
+// newTestTerminal returns a Terminal drawing pix to a simulated
+// terminal of the given size.
+func newTestTerminal(t *testing.T, braille bool, cols, rows int, pix *image.RGBA) (*Terminal, *debugOps) {
+	t.Helper()
+	s := tcell.NewSimulationScreen("UTF-8")
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Fini)
+	s.SetSize(cols, rows)
+
+	var d debugOps
+	v := newTestVarvara(t)
+	term := NewTerminal(v, &d, braille, nil, ASCIIText)
+	term.scr = s
+	if pix != nil {
+		term.pix = pix
+		term.size = pix.Bounds().Size()
+		term.updateView()
+	}
+	return term, &d
+}
+
+// gradient returns an image of the given size in which the red and
+// green components of each pixel are 16 times its x and y coordinates.
+func gradient(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetRGBA(x, y, gradientAt(x, y))
+		}
+	}
+	return m
+}
+
+func gradientAt(x, y int) color.RGBA {
+	return color.RGBA{uint8(x * 16), uint8(y * 16), 0, 0xff}
+}
+
+func tcellColor(c color.RGBA) tcell.Color {
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
+
+func TestTerminalPaintHalfBlock(t *testing.T) {
+	// A 4x4 screen in an 8x2 terminal is drawn at its actual size,
+	// centred horizontally.
+	term, _ := newTestTerminal(t, false, 8, 2, gradient(4, 4))
+	if got, want := term.view, image.Rect(2, 0, 6, 2); got != want {
+		t.Fatalf("view = %v, want %v", got, want)
+	}
+	term.paint()
+
+	s := term.scr
+	for cy := 0; cy < 2; cy++ {
+		for cx := 0; cx < 8; cx++ {
+			r, _, style, _ := s.GetContent(cx, cy)
+			if cx < 2 || cx >= 6 {
+				if r != ' ' {
+					t.Errorf("cell %d,%d outside the view = %q, want blank", cx, cy, r)
+				}
+				continue
+			}
+			fg, bg, _ := style.Decompose()
+			x := cx - 2
+			if want := gradientAt(x, cy*2); r != '▀' || fg != tcellColor(want) {
+				t.Errorf("cell %d,%d = %q in %v, want %q in %v", cx, cy, r, fg, '▀', want)
+			}
+			if want := gradientAt(x, cy*2+1); bg != tcellColor(want) {
+				t.Errorf("cell %d,%d background = %v, want %v", cx, cy, bg, want)
+			}
+		}
+	}
+}
+
+func TestTerminalPaintBraille(t *testing.T) {
+	var (
+		a = color.RGBA{0x10, 0, 0, 0xff}
+		b = color.RGBA{0x20, 0, 0, 0xff}
+		c = color.RGBA{0x30, 0, 0, 0xff}
+	)
+	for _, test := range []struct {
+		name   string
+		dots   [4][2]color.RGBA
+		r      rune
+		fg, bg color.RGBA
+	}{
+		{
+			name: "one color",
+			dots: [4][2]color.RGBA{{a, a}, {a, a}, {a, a}, {a, a}},
+			r:    0x2800,
+			bg:   a,
+		},
+		{
+			name: "two colors",
+			dots: [4][2]color.RGBA{{b, a}, {a, a}, {a, a}, {a, b}},
+			r:    0x2800 | 0x01 | 0x80,
+			fg:   b,
+			bg:   a,
+		},
+		{
+			// Every dot not of the background color is drawn,
+			// in the most common of the other colors.
+			name: "three colors",
+			dots: [4][2]color.RGBA{{a, c}, {a, b}, {a, b}, {a, a}},
+			r:    0x2800 | 0x08 | 0x10 | 0x20,
+			fg:   b,
+			bg:   a,
+		},
+		{
+			// Ties are broken in favour of the lesser color.
+			name: "tie",
+			dots: [4][2]color.RGBA{{b, a}, {b, a}, {b, a}, {b, a}},
+			r:    0x2800 | 0x01 | 0x02 | 0x04 | 0x40,
+			fg:   b,
+			bg:   a,
+		},
+	} {
+		pix := image.NewRGBA(image.Rect(0, 0, 2, 4))
+		for y := range test.dots {
+			for x, c := range test.dots[y] {
+				pix.SetRGBA(x, y, c)
+			}
+		}
+		term, _ := newTestTerminal(t, true, 1, 1, pix)
+		term.paint()
+		r, _, style, _ := term.scr.GetContent(0, 0)
+		fg, bg, _ := style.Decompose()
+		if r != test.r {
+			t.Errorf("%s: rune = %U, want %U", test.name, r, test.r)
+		}
+		if test.r != 0x2800 && fg != tcellColor(test.fg) {
+			t.Errorf("%s: foreground = %v, want %v", test.name, fg, test.fg)
+		}
+		if bg != tcellColor(test.bg) {
+			t.Errorf("%s: background = %v, want %v", test.name, bg, test.bg)
+		}
+	}
+}
+
+func TestTerminalDot(t *testing.T) {
+	// A 2x2 screen in a 4x2 terminal is drawn at twice its size,
+	// with two cells across and one cell down per pixel.
+	term, _ := newTestTerminal(t, false, 4, 2, gradient(2, 2))
+	if term.scale != 2 {
+		t.Fatalf("scale = %v, want 2", term.scale)
+	}
+	for _, test := range []struct {
+		cx, cy, dx, dy int
+		x, y           int
+	}{
+		{0, 0, 0, 0, 0, 0},
+		{1, 0, 0, 1, 0, 0},
+		{2, 0, 0, 0, 1, 0},
+		{3, 1, 0, 0, 1, 1},
+		{1, 1, 0, 1, 0, 1},
+	} {
+		if got, want := term.dot(test.cx, test.cy, test.dx, test.dy), gradientAt(test.x, test.y); got != want {
+			t.Errorf("dot(%d, %d, %d, %d) = %v, want pixel %d,%d %v",
+				test.cx, test.cy, test.dx, test.dy, got, test.x, test.y, want)
+		}
+	}
+}
+
+func TestTerminalKeyCode(t *testing.T) {
+	for _, test := range []struct {
+		key  tcell.Key
+		r    rune
+		code key.Code
+	}{
+		{tcell.KeyHome, 0, key.CodeHome},
+		{tcell.KeyUp, 0, key.CodeUpArrow},
+		{tcell.KeyDown, 0, key.CodeDownArrow},
+		{tcell.KeyLeft, 0, key.CodeLeftArrow},
+		{tcell.KeyRight, 0, key.CodeRightArrow},
+		{tcell.KeyEnter, 0, key.CodeReturnEnter},
+		{tcell.KeyTab, 0, key.CodeTab},
+		{tcell.KeyEscape, 0, key.CodeEscape},
+		{tcell.KeyBackspace, 0, key.CodeDeleteBackspace},
+		{tcell.KeyBackspace2, 0, key.CodeDeleteBackspace},
+		{tcell.KeyDelete, 0, key.CodeDeleteForward},
+		{tcell.KeyRune, 'a', key.CodeA},
+		{tcell.KeyRune, 'Z', key.CodeZ},
+		{tcell.KeyRune, '1', key.Code1},
+		{tcell.KeyRune, '9', key.Code9},
+		{tcell.KeyRune, '0', key.Code0},
+		{tcell.KeyRune, ' ', key.CodeSpacebar},
+		{tcell.KeyRune, 'é', 0},
+		{tcell.KeyF1, 0, 0},
+	} {
+		e := tcell.NewEventKey(test.key, test.r, tcell.ModNone)
+		if got := terminalKeyCode(e); got != test.code {
+			t.Errorf("terminalKeyCode(%v) = %v, want %v", e.Name(), got, test.code)
+		}
+	}
+}
+
+func TestTerminalKeys(t *testing.T) {
+	term, d := newTestTerminal(t, false, 4, 2, nil)
+	v := term.v
+
+	if err := term.handleKey(tcell.NewEventKey(tcell.KeyCtrlC, 0, tcell.ModCtrl)); err != errCloseGUI {
+		t.Errorf("Ctrl-C returned %v, want %v", err, errCloseGUI)
+	}
+	for _, k := range []tcell.Key{tcell.KeyF4, tcell.KeyF5, tcell.KeyF6, tcell.KeyF7} {
+		term.handleKey(tcell.NewEventKey(k, 0, tcell.ModNone))
+	}
+	if got, want := []string(*d), []string{"reset", "cont", "step", "halt"}; !equalStrings(got, want) {
+		t.Errorf("debug commands = %q, want %q", got, want)
+	}
+
+	term.handleKey(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModCtrl))
+	if s, _ := v.cntrl.pop(); !s.A || s.Key != 'x' {
+		t.Errorf("after Ctrl-x, state = %+v, want A held and key 'x'", s)
+	}
+	term.handleKey(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if s, _ := v.cntrl.pop(); s.Key != 0x0d {
+		t.Errorf("after Enter, key = %#x, want 0x0d", s.Key)
+	}
+}
+
+func TestTerminalKeyHold(t *testing.T) {
+	term, _ := newTestTerminal(t, false, 4, 2, nil)
+	v := term.v
+
+	term.handleKey(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
+	if s, _ := v.cntrl.pop(); !s.Up {
+		t.Fatalf("after Up, state = %+v, want Up held", s)
+	}
+
+	// A button is held until its hold time has passed.
+	term.update()
+	if _, ok := v.cntrl.pop(); ok {
+		t.Error("button released before its hold time")
+	}
+	for b := range term.held {
+		term.held[b] = time.Now().Add(-time.Millisecond)
+	}
+	term.update()
+	if s, ok := v.cntrl.pop(); !ok || s.Up {
+		t.Errorf("after hold time, state = %+v, %v; want Up released", s, ok)
+	}
+	if len(term.held) != 0 {
+		t.Errorf("%d buttons still held", len(term.held))
+	}
+}
+
+func TestTerminalMouse(t *testing.T) {
+	term, _ := newTestTerminal(t, false, 4, 2, nil)
+	v := term.v
+
+	// Before the screen is drawn there is nowhere to point.
+	term.handleMouse(tcell.NewEventMouse(1, 1, tcell.Button1, tcell.ModNone))
+	if _, ok := v.mouse.pop(); ok {
+		t.Error("mouse event delivered before the screen was drawn")
+	}
+
+	// A 4x4 screen drawn at its actual size,
+	// so each cell covers one pixel across and two down.
+	term.pix = gradient(4, 4)
+	term.size = term.pix.Bounds().Size()
+	term.updateView()
+	for _, test := range []struct {
+		x, y    int
+		buttons tcell.ButtonMask
+		want    MouseState
+	}{
+		{0, 0, tcell.ButtonNone, MouseState{X: 0, Y: 1}},
+		{2, 1, tcell.Button1, MouseState{X: 2, Y: 3, Button: [3]bool{true, false, false}}},
+		{3, 0, tcell.Button2, MouseState{X: 3, Y: 1, Button: [3]bool{false, false, true}}},
+		{3, 0, tcell.Button3, MouseState{X: 3, Y: 1, Button: [3]bool{false, true, false}}},
+		{1, 1, tcell.WheelUp, MouseState{X: 1, Y: 3, ScrollY: -1}},
+		{1, 1, tcell.WheelDown, MouseState{X: 1, Y: 3, ScrollY: 1}},
+		{1, 1, tcell.WheelLeft, MouseState{X: 1, Y: 3, ScrollX: -1}},
+		{1, 1, tcell.WheelRight, MouseState{X: 1, Y: 3, ScrollX: 1}},
+	} {
+		term.handleMouse(tcell.NewEventMouse(test.x, test.y, test.buttons, tcell.ModNone))
+		if got, ok := v.mouse.pop(); !ok || got != test.want {
+			t.Errorf("mouse at %d,%d with %v = %+v, %v; want %+v", test.x, test.y, test.buttons, got, ok, test.want)
+		}
+	}
+	// Scrolling is reported once and does not persist.
+	if term.mouse.ScrollX != 0 || term.mouse.ScrollY != 0 {
+		t.Errorf("terminal mouse state kept scroll %d,%d", term.mouse.ScrollX, term.mouse.ScrollY)
+	}
+}
+
+func TestHeldOutput(t *testing.T) {
+	var (
+		out bytes.Buffer
+		h   = &heldOutput{w: &out}
+	)
+	io.WriteString(h, "hello, ")
+	io.WriteString(h, "world")
+	if out.Len() != 0 {
+		t.Errorf("output %q written before flush", out.String())
+	}
+	h.flush()
+	if got, want := out.String(), "hello, world"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	h.flush()
+	if got, want := out.String(), "hello, world"; got != want {
+		t.Errorf("after second flush, output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package varvara
+
+import (
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// TextInput specifies how text typed into a UI is delivered to Varvara.
+type TextInput int
+
+const (
+	// ASCIIText delivers ASCII characters on the Controller key port
+	// and drops all others.
+	ASCIIText TextInput = iota
+
+	// UTF8Text delivers text on the Controller key port
+	// encoded as UTF-8, one event per byte.
+	UTF8Text
+
+	// ConsoleText delivers ASCII characters on the Controller key port,
+	// as ASCIIText does, and all text to the Console device instead of
+	// standard input, encoded as UTF-8, one event per byte.
+	ConsoleText
+)
+
+// sendKey queues the controller state s, whose Key field holds the ASCII
+// value of the key that was pressed or zero, and delivers r, the non-ASCII
+// rune that the key typed or zero, to v as specified by mode.
+// It resets s.Key.
+func sendKey(v *Varvara, s *ControllerState, mode TextInput, r rune) {
+	switch {
+	case mode == UTF8Text && s.Key == 0 && r >= utf8.RuneSelf:
+		for _, b := range utf8.AppendRune(nil, r) {
+			s.Key = b
+			v.cntrl.Set(s)
+		}
+	case mode == ConsoleText:
+		switch {
+		case s.Key == '\r':
+			// Deliver a newline, as a terminal would.
+			v.con.typeText([]byte{'\n'})
+		case s.Key != 0:
+			v.con.typeText([]byte{s.Key})
+		case r >= utf8.RuneSelf:
+			v.con.typeText(utf8.AppendRune(nil, r))
+		}
+		v.cntrl.Set(s)
+	default:
+		v.cntrl.Set(s)
+	}
+	s.Key = 0 // Report each key press only once.
+}
+
+// textBuffer is a pipe for typed text. Writes never block,
+// while reads block until there is text to read.
+type textBuffer struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  []byte
+
+	closed bool
+}
+
+func newTextBuffer() *textBuffer {
+	b := &textBuffer{}
+	b.cond.L = &b.mu
+	return b
+}
+
+func (b *textBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.buf = append(b.buf, p...)
+	b.cond.Signal()
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+func (b *textBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.buf) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// close makes reads return io.EOF once the buffered text has been read.
+func (b *textBuffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
@@ -0,0 +1,75 @@
+package varvara
+
+import (
+	"io"
+	"testing"
+)
+
+func TestSendKeyUTF8(t *testing.T) {
+	v := newTestVarvara(t)
+	v.cntrl.mem.setShort(0x0, 0x1234) // vector
+
+	var s ControllerState
+	s.Key = 'a'
+	sendKey(v, &s, UTF8Text, 0)
+	sendKey(v, &s, UTF8Text, 'é')
+	sendKey(v, &s, UTF8Text, '€')
+	sendKey(v, &s, ASCIIText, 'é') // dropped
+	if s.Key != 0 {
+		t.Errorf("after sendKey, s.Key = %q, want 0", s.Key)
+	}
+
+	var got []byte
+	for {
+		select {
+		case <-v.cntrl.Ready:
+		default:
+			if want := "aé€"; string(got) != want {
+				t.Errorf("key port received %q, want %q", got, want)
+			}
+			return
+		}
+		if v.cntrl.next() != 0 {
+			got = append(got, v.cntrl.In(0x3))
+		}
+		v.cntrl.clearKey()
+	}
+}
+
+func TestSendKeyConsole(t *testing.T) {
+	v := newTestVarvara(t)
+	v.con.readTyped()
+
+	v.cntrl.mem.setShort(0x0, 0x1234) // vector
+
+	var s ControllerState
+	for _, c := range []struct {
+		key byte
+		r   rune
+	}{{'h', 0}, {0, 'ï'}, {'\r', 0}} {
+		s.Key = c.key
+		sendKey(v, &s, ConsoleText, c.r)
+	}
+
+	want := "hï\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(v.con.in, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("console received %q, want %q", got, want)
+	}
+
+	// ASCII keys are also delivered on the key port.
+	var keys []byte
+	for len(v.cntrl.Ready) > 0 {
+		<-v.cntrl.Ready
+		if v.cntrl.next() != 0 {
+			keys = append(keys, v.cntrl.In(0x3))
+		}
+		v.cntrl.clearKey()
+	}
+	if want := "h\r"; string(keys) != want {
+		t.Errorf("key port received %q, want %q", keys, want)
+	}
+}
@@ -0,0 +1,51 @@
+package varvara
+
+// UI presents the Varvara screen and feeds user input to its devices.
+type UI interface {
+	// Run drives the UI and the screen vector until exit is closed or
+	// the user closes the UI.
+	Run(exit <-chan bool) error
+
+	// Swap replaces the Varvara attached to the UI with the given one.
+	// This operation takes effect on the next update event.
+	// Swap may only be called when a UI update is not in progress.
+	Swap(v *Varvara)
+
+	frame() *frameSync
+}
+
+// Display selects how a Runner presents the Varvara screen.
+type Display int
+
+const (
+	NoDisplay       Display = iota // Console only.
+	WindowDisplay                  // A native GUI window.
+	TerminalDisplay                // The terminal, with half-block characters.
+	BrailleDisplay                 // The terminal, with braille characters.
+)
+
+// frameSync synchronizes a UI's frames with the Varvara CPU.
+// When the CPU is idle it sends on Update and then waits on UpdateDone,
+// and in between the UI may safely read and modify the Varvara's state.
+type frameSync struct {
+	Update     chan<- bool
+	UpdateDone <-chan bool
+
+	doUpdate   <-chan bool
+	updateDone chan<- bool
+}
+
+func newFrameSync() frameSync {
+	up, done := make(chan bool), make(chan bool)
+	return frameSync{
+		Update: up, doUpdate: up,
+		UpdateDone: done, updateDone: done,
+	}
+}
+
+func (f *frameSync) frame() *frameSync { return f }
+
+var (
+	_ UI = &GUI{}
+	_ UI = &Terminal{}
+)
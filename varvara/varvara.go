@@ -2,25 +2,50 @@
 package varvara
 
 import (
+	"fmt"
+	"image"
 	"io"
 	"log"
 	"os"
 	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nf/nux/uxn"
 )
 
 type Runner struct {
-	gui   bool
-	dev   bool
-	state StateFunc
+	display Display
+	dev     bool
+	state   StateFunc
+	scaling Scaling
+	keys    KeyMap
+	text    TextInput
+	record  io.Writer
+	replay  *InputRecording
+	args    []string
+	raw     bool
+	time    *timeSource
+	fs      FS
+	title   string
+	allow   []string
+
+	onMisuse     func(Misuse)
+	haltOnMisuse bool
+
+	last *Varvara // the most recently run machine, once Run returns
 
 	swap     chan []byte
 	swapDone chan bool
 	debug    chan debugOp
+	stop     chan struct{} // closed by Stop
+	stopOnce sync.Once
 
+	stdin          io.Reader
 	stdout, stderr io.Writer
+	noStdin        bool         // the console does not read stdin
+	input          *sharedInput // reads stdin for each machine in turn
 }
 
 type StateFunc func(*uxn.Machine, StateKind)
@@ -36,17 +61,19 @@ const (
 	QuietState
 )
 
-func NewRunner(enableGUI, devMode bool, state StateFunc) *Runner {
+func NewRunner(display Display, devMode bool, state StateFunc) *Runner {
 	if state == nil {
 		state = func(*uxn.Machine, StateKind) {}
 	}
 	return &Runner{
-		gui:      enableGUI,
+		display:  display,
 		dev:      devMode,
 		state:    state,
 		swap:     make(chan []byte),
 		swapDone: make(chan bool),
 		debug:    make(chan debugOp),
+		stop:     make(chan struct{}),
+		time:     newTimeSource(),
 
 		stdout: os.Stdout,
 		stderr: os.Stderr,
@@ -63,8 +90,95 @@ func (r *Runner) SetOutput(w io.Writer) {
 	r.stderr = w
 }
 
+// SetStdout sets the writer to which the Console device writes
+// its standard output. It must be called before Run.
+func (r *Runner) SetStdout(w io.Writer) { r.stdout = w }
+
+// SetInput sets the reader from which the Console device reads input,
+// instead of standard input. It must be called before Run.
+func (r *Runner) SetInput(rd io.Reader) { r.stdin = rd }
+
+// SetScaling sets the scaling behavior of the GUI window.
+// It must be called before Run.
+func (r *Runner) SetScaling(s Scaling) { r.scaling = s }
+
+// SetKeyMap sets the mapping from keys to the Controller device.
+// It must be called before Run.
+func (r *Runner) SetKeyMap(k KeyMap) { r.keys = k }
+
+// SetTextInput sets how text typed into the UI is delivered.
+// It must be called before Run.
+func (r *Runner) SetTextInput(t TextInput) { r.text = t }
+
+// SetRecordInput makes the Runner write each controller, mouse, and
+// console event delivered to the Varvara machine to w, in the format
+// described by InputRecording. It must be called before Run.
+func (r *Runner) SetRecordInput(w io.Writer) { r.record = w }
+
+// SetReplayInput makes the Runner deliver the events in rec to the
+// Varvara machine, on the frames they were recorded, instead of the input
+// from its UI and standard input. Frames are run as fast as possible,
+// and the machine stops once the recorded number of frames has been run.
+// It must be called before Run, and should be used with NoDisplay.
+func (r *Runner) SetReplayInput(rec *InputRecording) { r.replay = rec }
+
+// SetRawConsole configures the Console device for a terminal in raw
+// mode: arrow key escape sequences in its input are delivered as presses
+// of the Controller's direction buttons, and newlines in its output are
+// written as CR LF. It must be called before Run.
+func (r *Runner) SetRawConsole(raw bool) { r.raw = raw }
+
+// SetArgs sets the command-line arguments passed to the Console device.
+// It must be called before Run.
+func (r *Runner) SetArgs(args []string) { r.args = args }
+
+// SetFS sets the file system used by the File devices.
+// By default they use the current directory.
+// It must be called before Run.
+func (r *Runner) SetFS(fsys FS) { r.fs = fsys }
+
+// SetAllowedCommands sets the names of the host commands that programs may
+// run with the Console device, as described in process.go. By default
+// no commands may be run. It must be called before Run.
+func (r *Runner) SetAllowedCommands(names []string) { r.allow = names }
+
+// SetMisuseFunc sets a function to be called with each misuse of a
+// device, instead of logging it. It must be called before Run.
+func (r *Runner) SetMisuseFunc(f func(Misuse)) { r.onMisuse = f }
+
+// SetHaltOnMisuse makes the misuse of a device halt the program, with
+// halt code MisuseHalt, as well as being reported. The System device's
+// halt vector is run if it is set. It must be called before Run.
+func (r *Runner) SetHaltOnMisuse(halt bool) { r.haltOnMisuse = halt }
+
+// SetTitle sets the title of the GUI window. It must be called before Run.
+func (r *Runner) SetTitle(title string) { r.title = title }
+
+// Screenshot returns the screen of the Varvara machine, with its
+// foreground layer drawn over its background.
+// It may only be called after Run returns.
+func (r *Runner) Screenshot() *image.RGBA { return r.last.scr.image() }
+
+// SetClock sets the clock used by the Datetime device.
+// It may be called at any time.
+func (r *Runner) SetClock(c Clock) { r.time.set(c) }
+
+// SetLocation sets the time zone reported by the Datetime device.
+// It may be called at any time.
+func (r *Runner) SetLocation(loc *time.Location) { r.time.setLocation(loc) }
+
+// Now returns the time that the Datetime device currently reports.
+func (r *Runner) Now() time.Time { return r.time.now() }
+
 func (r *Runner) Debug(cmd string, addr uint16) { r.debug <- debugOp{cmd, addr} }
 
+// Stop halts the program and makes Run return, such as when nux is
+// interrupted. It may be called from any goroutine, before or during
+// Run, and more than once.
+func (r *Runner) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
 func (r *Runner) Swap(rom []byte) {
 	if !r.dev {
 		panic("Reset called while not running in dev mode")
@@ -73,22 +187,68 @@ func (r *Runner) Swap(rom []byte) {
 	<-r.swapDone
 }
 
-func (r *Runner) Run(rom []byte) (exitCode int) {
+// Run runs rom until it exits, and returns its exit code.
+// If the display fails, Run stops the program and returns the error.
+func (r *Runner) Run(rom []byte) (exitCode int, err error) {
+	if r.display == TerminalDisplay || r.display == BrailleDisplay {
+		defer r.detachTerminal()()
+	}
 	var v *Varvara
 	newV := func() {
 		prev := v
 		v = New(rom, r.state, r.stdout, r.stderr)
+		v.time.src = r.time
+		v.headless = r.display == NoDisplay
+		if r.input == nil && !r.noStdin {
+			stdin := r.stdin
+			if stdin == nil {
+				stdin = os.Stdin
+			}
+			r.input = newSharedInput(stdin)
+		}
+		if r.input != nil {
+			v.con.in = r.input.reader(v.con.done)
+		}
+		v.con.setArgs(r.args)
+		v.con.allow = r.allow
+		v.onMisuse, v.haltOnMisuse = r.onMisuse, r.haltOnMisuse
+		if r.raw {
+			v.con.setRaw(&v.cntrl)
+		}
+		if r.fs != nil {
+			v.setFS(r.fs)
+		}
+		if r.text == ConsoleText {
+			v.con.readTyped()
+		}
+		if r.record != nil {
+			v.recordInput(r.record)
+		}
+		if r.replay != nil {
+			v.replayInput(r.replay)
+		}
 		if prev != nil {
 			v.debugAddr = prev.debugAddr
 			v.breakAddrs.Store(prev.breakAddrs.Load())
+			prev.Close()
 		}
 		v.state(v.m, ClearState)
 	}
 	newV()
 	var (
-		g    = NewGUI(v, r)
+		g    UI
 		exit = make(chan bool)
 	)
+	switch r.display {
+	case TerminalDisplay, BrailleDisplay:
+		g = NewTerminal(v, r, r.display == BrailleDisplay, r.keys, r.text)
+	default:
+		gui := NewGUI(v, r, r.scaling, r.keys, r.text)
+		if r.title != "" {
+			gui.title = r.title
+		}
+		g = gui
+	}
 	go func() {
 		var (
 			execErr = make(chan error)
@@ -110,7 +270,11 @@ func (r *Runner) Run(rom []byte) (exitCode int) {
 			}
 			running = false
 			v.Halt()
-			if err := <-execErr; err != nil {
+			err := <-execErr
+			if !r.dev {
+				return
+			}
+			if err != nil {
 				log.Printf("uxn: stopped: %v", err)
 			} else {
 				log.Printf("uxn: stopped")
@@ -159,19 +323,31 @@ func (r *Runner) Run(rom []byte) (exitCode int) {
 					close(exit)
 					return
 				}
+			case <-r.stop:
+				halt()
+				close(exit)
+				return
 			}
 		}
 	}()
-	if r.gui {
-		// If the GUI is enabled then Run will drive the GUI and the
+	if r.display != NoDisplay {
+		// If a display is enabled then Run will drive the UI and the
 		// screen vector until exit is closed.
-		if err := g.Run(exit); err != nil {
-			log.Fatalf("gui: %v", err)
+		if uiErr := g.Run(exit); uiErr != nil {
+			err = fmt.Errorf("ui: %v", uiErr)
+		}
+		// If the user closed the UI, stop the machine.
+		select {
+		case r.debug <- debugOp{cmd: "exit"}:
+			<-exit
+		case <-exit:
 		}
 	} else {
 		<-exit
 	}
-	return v.sys.ExitCode()
+	v.Close()
+	r.last = v
+	return v.sys.ExitCode(), err
 }
 
 type Varvara struct {
@@ -187,6 +363,14 @@ type Varvara struct {
 
 	state StateFunc
 
+	headless bool   // there is no UI to drive the screen and input vectors
+	frame    uint64 // the number of screen updates so far
+	recorder *inputRecorder
+	replay   *inputReplay
+
+	onMisuse     func(Misuse) // or nil to log misuse
+	haltOnMisuse bool
+
 	// Atomics
 	paused     int32
 	breakAddrs atomic.Value // addrSet
@@ -209,6 +393,8 @@ func New(rom []byte, state StateFunc, stdout, stderr io.Writer) *Varvara {
 	v.sys.main = m.Mem[:]
 	v.sys.m = m
 	v.sys.state = state
+	v.con.main = m.Mem[:]
+	v.con.done = make(chan struct{})
 	v.con.in = os.Stdin
 	v.con.out = stdout
 	v.con.err = stderr
@@ -216,12 +402,28 @@ func New(rom []byte, state StateFunc, stdout, stderr io.Writer) *Varvara {
 	v.scr.sys = &v.sys
 	v.scr.setWidth(0x100)
 	v.scr.setHeight(0x100)
+	v.cntrl.init()
+	v.mouse.init()
 	v.fileA.main = m.Mem[:]
 	v.fileB.main = m.Mem[:]
+	v.fileA.misuse = v.misuseFunc("file", 0xa0)
+	v.fileB.misuse = v.misuseFunc("file", 0xb0)
+	v.setFS(DirFS("."))
+	v.time.src = newTimeSource()
 	v.breakAddrs.Store(addrSet(nil))
 	return v
 }
 
+// Close releases the resources held by the devices of v: it closes any
+// open files, stops any host command run by the Console device, and
+// stops reading console input. It must only be called once v has
+// stopped executing. Calling Close more than once has no effect.
+func (v *Varvara) Close() {
+	v.fileA.close()
+	v.fileB.close()
+	v.con.close()
+}
+
 func (v *Varvara) Halt() {
 	if !v.halted {
 		close(v.halt)
@@ -264,8 +466,12 @@ func (v *Varvara) RemoveBreak(addr uint16) {
 	}
 }
 
-func (v *Varvara) Exec(g *GUI) error {
+func (v *Varvara) Exec(g UI) error {
+	f := g.frame()
 	defer v.state(v.m, HaltState)
+	if v.recorder != nil {
+		defer v.recorder.end()
+	}
 	for {
 		clear := false
 		for {
@@ -319,18 +525,40 @@ func (v *Varvara) Exec(g *GUI) error {
 		} else {
 			v.state(v.m, QuietState)
 		}
+		v.cntrl.clearKey()
+		v.mouse.clearScroll()
 
 		var vector uint16
+		for vector == 0 && len(v.con.args) > 0 {
+			vector = v.con.nextArg()
+		}
+		for vector == 0 && v.replay != nil {
+			select {
+			case <-v.halt:
+				return nil
+			default:
+			}
+			var ok bool
+			if vector, ok = v.nextReplay(); !ok {
+				return nil
+			}
+		}
 		for vector == 0 {
+			if v.headless && !v.con.listening() {
+				// Without a UI, only console input can trigger
+				// a vector, so there is nothing left to do.
+				return nil
+			}
 			select {
 			case <-v.con.Ready:
-				vector = v.con.Vector()
+				vector = v.con.next()
 			case <-v.cntrl.Ready:
-				vector = v.cntrl.Vector()
+				vector = v.cntrl.next()
 			case <-v.mouse.Ready:
-				vector = v.mouse.Vector()
-			case g.Update <- true:
-				<-g.UpdateDone
+				vector = v.mouse.next()
+			case f.Update <- true:
+				<-f.UpdateDone
+				v.nextFrame()
 				vector = v.scr.Vector()
 			case <-v.halt:
 				return nil
@@ -340,6 +568,18 @@ func (v *Varvara) Exec(g *GUI) error {
 	}
 }
 
+// setFS sets the file system used by the File devices.
+func (v *Varvara) setFS(fsys FS) {
+	v.fileA.fs = fsys
+	v.fileB.fs = fsys
+}
+
+// nextFrame advances the frame counter and any frame-locked clock.
+func (v *Varvara) nextFrame() {
+	v.frame++
+	v.time.src.tick()
+}
+
 func (v *Varvara) In(p byte) byte {
 	dev := p & 0xf0
 	p &= 0xf
@@ -0,0 +1,158 @@
+package varvara
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nf/nux/uxn"
+)
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent use.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// countingFS is a MemFS that counts the files open for writing.
+type countingFS struct {
+	MemFS
+	open atomic.Int32
+}
+
+func (c *countingFS) OpenFile(name string, flag int) (io.WriteCloser, error) {
+	w, err := c.MemFS.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	c.open.Add(1)
+	return &countingWriter{WriteCloser: w, open: &c.open}, nil
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	open *atomic.Int32
+}
+
+func (w *countingWriter) Close() error {
+	w.open.Add(-1)
+	return w.WriteCloser.Close()
+}
+
+// waitFor waits until cond is true, failing the test if that
+// takes too long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunnerSwapLeaks(t *testing.T) {
+	// The console vector echoes its input, and the reset vector
+	// leaves out.txt open for writing.
+	handler := make([]byte, 0x20)
+	copy(handler, cat(printPort(0x12), []byte{byte(uxn.BRK)}))
+	copy(handler[0x10:], "out.txt\x00")
+	rom := assemble(handler,
+		deo2(0x10, spriteAddr),
+		deo2(0xa8, spriteAddr+0x10), deo2(0xaa, 1), deo2(0xae, spriteAddr),
+	)
+
+	var (
+		in, inW = io.Pipe()
+		out     lockedBuffer
+		fsys    countingFS
+		r       = NewRunner(NoDisplay, true, nil)
+		done    = make(chan bool)
+	)
+	r.SetInput(in)
+	r.SetOutput(&out)
+	r.SetFS(&fsys)
+	go func() {
+		r.Run(rom)
+		close(done)
+	}()
+
+	io.WriteString(inW, "a")
+	waitFor(t, "first input", func() bool { return out.String() == "a" })
+	waitFor(t, "file to be opened", func() bool { return fsys.open.Load() == 1 })
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		r.Swap(rom)
+	}
+
+	// Input is delivered to the current machine only,
+	// once it has run its reset vector.
+	io.WriteString(inW, "b")
+	waitFor(t, "second input", func() bool { return out.String() == "ab" })
+	if n := fsys.open.Load(); n != 1 {
+		t.Errorf("%d files open after swaps, want 1", n)
+	}
+	waitFor(t, "goroutines of old machines to exit", func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	})
+
+	r.Debug("exit", 0)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if n := fsys.open.Load(); n != 0 {
+		t.Errorf("%d files left open after Run", n)
+	}
+}
+
+func TestVarvaraCloseTyped(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	v := newTestVarvara(t)
+	v.con.readTyped()
+	v.con.Out(0x1, 0x01) // vector; starts reading typed text
+	v.Close()
+	waitFor(t, "console goroutine to exit", func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	})
+	v.Close()
+}
+
+func TestRunnerStop(t *testing.T) {
+	// The program waits for console input that never arrives.
+	rom := assemble(nil, deo2(0x10, 0x0106))
+	in, _ := io.Pipe()
+	r := NewRunner(NoDisplay, false, nil)
+	r.SetInput(in)
+	done := make(chan bool)
+	go func() {
+		r.Run(rom)
+		close(done)
+	}()
+	r.Stop()
+	r.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+}